@@ -0,0 +1,542 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// IsTautology reports whether the filter s matches any value of the fields it
+// checks, e.g. `$.a = x || $.a != x`. Only complementary clauses over a single
+// field are detected, reasoning across multiple fields is out of scope.
+func IsTautology(s string) (bool, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return false, err
+	}
+
+	return isTautology(exp), nil
+}
+
+// IsContradiction reports whether the filter s can never match, e.g.
+// `$.a = x && $.a != x`. As with IsTautology, only single field reasoning is
+// supported.
+func IsContradiction(s string) (bool, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return false, err
+	}
+
+	return isContradiction(exp), nil
+}
+
+func isTautology(e Expression) bool {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return false // a single clause always depends on the log event
+	}
+
+	switch c.operator {
+	case loOr:
+		if hasComplementaryClauses(c.expressions) {
+			return true
+		}
+
+		for _, exp := range c.expressions {
+			if isTautology(exp) {
+				return true
+			}
+		}
+	case loAnd:
+		for _, exp := range c.expressions {
+			if !isTautology(exp) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func isContradiction(e Expression) bool {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return false
+	}
+
+	switch c.operator {
+	case loAnd:
+		if hasComplementaryClauses(c.expressions) {
+			return true
+		}
+
+		for _, exp := range c.expressions {
+			if isContradiction(exp) {
+				return true
+			}
+		}
+	case loOr:
+		for _, exp := range c.expressions {
+			if !isContradiction(exp) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// hasComplementaryClauses checks if expressions holds both `a = x` and `a != x`
+func hasComplementaryClauses(expressions []Expression) bool {
+	for i, exp := range expressions {
+		s, ok := exp.(simpleExpression)
+		if !ok || s.operator != coNotEqual {
+			continue
+		}
+
+		// An equal clause with the same operands is the complement of s
+		complement := simpleExpression{left: s.left, operator: coEqual, right: s.right}
+		for j, other := range expressions {
+			if i != j && complement.isEquivalent(other) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Subsumes reports whether the filter a matches every log event matched by b,
+// that is, a is the same as b or broader. `$.x > 5` subsumes `$.x > 10` as any
+// value above 10 is above 5, while `$.x > 10` doesn't subsume `$.x > 5`.
+//
+// Numeric thresholds are only compared on the same selector, and groups are
+// reasoned about clause by clause, so a false result means subsumption could
+// not be proven rather than that it doesn't hold.
+func Subsumes(a, b string) (bool, error) {
+	expA, err := parse(a)
+	if err != nil {
+		return false, err
+	}
+
+	expB, err := parse(b)
+	if err != nil {
+		return false, err
+	}
+
+	return subsumes(expA, expB), nil
+}
+
+func subsumes(a, b Expression) bool {
+	if a.isEquivalent(b) {
+		return true
+	}
+
+	// reason on `$.x IN [a, b]` as the group `$.x = a || $.x = b`, and likewise
+	// on NOT IN
+	if s, ok := a.(simpleExpression); ok && s.operator.isList() {
+		return subsumes(s.expanded(), b)
+	}
+	if s, ok := b.(simpleExpression); ok && s.operator.isList() {
+		return subsumes(a, s.expanded())
+	}
+
+	// a must match every alternative of b
+	if c, ok := b.(complexExpression); ok && c.operator == loOr {
+		return every(c.expressions, func(exp Expression) bool { return subsumes(a, exp) })
+	}
+
+	// every condition of a must hold for b
+	if c, ok := a.(complexExpression); ok && c.operator == loAnd {
+		return every(c.expressions, func(exp Expression) bool { return subsumes(exp, b) })
+	}
+
+	// a single alternative of a holding for b is enough
+	if c, ok := a.(complexExpression); ok && c.operator == loOr {
+		for _, exp := range c.expressions {
+			if subsumes(exp, b) {
+				return true
+			}
+		}
+	}
+
+	// a single condition of b narrows it enough
+	if c, ok := b.(complexExpression); ok && c.operator == loAnd {
+		for _, exp := range c.expressions {
+			if subsumes(a, exp) {
+				return true
+			}
+		}
+	}
+
+	simpleA, okA := a.(simpleExpression)
+	simpleB, okB := b.(simpleExpression)
+	return okA && okB && subsumesNumeric(simpleA, simpleB)
+}
+
+func every(expressions []Expression, f func(Expression) bool) bool {
+	for _, exp := range expressions {
+		if !f(exp) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// subsumesNumeric compares the thresholds of two clauses over the same selector.
+// b may also be an equal clause, as `$.x = 7` is subsumed by `$.x > 5`. Values
+// like `inf` or `0x10` are words rather than numbers, even though Go parses them.
+func subsumesNumeric(a, b simpleExpression) bool {
+	a, b = a.selectorFirst(), b.selectorFirst()
+	if a.left != b.left || !isNumber(a.right) || !isNumber(b.right) {
+		return false
+	}
+
+	limitA, errA := strconv.ParseFloat(a.right, 64)
+	limitB, errB := strconv.ParseFloat(b.right, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	switch a.operator {
+	case coGreaterThan:
+		return (b.operator == coGreaterThan && limitB >= limitA) ||
+			((b.operator == coGreaterThanOrEqual || b.operator == coEqual) && limitB > limitA)
+	case coGreaterThanOrEqual:
+		return (b.operator == coGreaterThan || b.operator == coGreaterThanOrEqual || b.operator == coEqual) && limitB >= limitA
+	case coLessThan:
+		return (b.operator == coLessThan && limitB <= limitA) ||
+			((b.operator == coLessThanOrEqual || b.operator == coEqual) && limitB < limitA)
+	case coLessThanOrEqual:
+		return (b.operator == coLessThan || b.operator == coLessThanOrEqual || b.operator == coEqual) && limitB <= limitA
+	case coEqual:
+		return b.operator == coEqual && limitA == limitB
+	}
+
+	return false
+}
+
+// IsStricterThan reports whether the filter a only matches log events that b
+// matches too, so a is at least as restrictive as b. It's meant to check a new
+// filter against a baseline, both being a single clause or an AND group of
+// clauses. Other filters return an error.
+//
+// The scope is limited to clause supersets, `$.a = x && $.b = y` being
+// stricter than `$.a = x`, and to numeric thresholds over the same selector,
+// `$.x > 10 && $.x < 20` being stricter than `$.x > 5`. A false result means
+// it could not be proven rather than that a is broader.
+func IsStricterThan(a, b string) (bool, error) {
+	expA, err := parseAndClauses(a)
+	if err != nil {
+		return false, err
+	}
+
+	expB, err := parseAndClauses(b)
+	if err != nil {
+		return false, err
+	}
+
+	return subsumes(expB, expA), nil
+}
+
+// parseAndClauses parses s as a single clause or an AND group of clauses
+func parseAndClauses(s string) (Expression, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := exp.(complexExpression); ok {
+		if c.operator != loAnd {
+			return nil, errors.New("expected an AND group of simple clauses")
+		}
+
+		for _, clause := range c.expressions {
+			if _, ok := clause.(simpleExpression); !ok {
+				return nil, errors.New("expected an AND group of simple clauses")
+			}
+		}
+	}
+
+	return exp, nil
+}
+
+// Overlaps reports whether a log event may match both filters a and b, e.g.
+// `$.eventName = CreateTrail || $.eventName = UpdateTrail` and
+// `$.eventName = UpdateTrail || $.eventName = DeleteTrail` share UpdateTrail.
+// Filters overlap unless every alternative of a conflicts with every
+// alternative of b on a field: different values, a value and its negation, a
+// value and NOT EXISTS, or numeric thresholds with no value in common.
+//
+// Flat filters are the intended scope. Nested groups are expanded into their
+// alternatives, which grow with every AND of OR groups, and conflicts are only
+// found between two clauses, so a true result means no conflict could be
+// proven rather than that a shared log event exists. Past maxAlternatives the
+// filters aren't expanded any further and are reported as overlapping.
+func Overlaps(a, b string) (bool, error) {
+	expA, err := parse(a)
+	if err != nil {
+		return false, err
+	}
+
+	expB, err := parse(b)
+	if err != nil {
+		return false, err
+	}
+
+	// an event matching both filters matches an alternative of a AND b
+	alts, ok := alternatives(complexExpression{operator: loAnd, expressions: []Expression{expA, expB}})
+	if !ok {
+		return true, nil
+	}
+
+	for _, alt := range alts {
+		if !conflicting(alt) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// maxAlternatives is how many alternatives Overlaps expands filters into
+// before giving up, each AND of OR groups multiplying them
+const maxAlternatives = 1024
+
+// alternatives returns the clauses of e in disjunctive form, e matching when
+// all the clauses of any alternative match, or false when there are more than
+// maxAlternatives of them
+func alternatives(e Expression) ([][]simpleExpression, bool) {
+	switch exp := e.(type) {
+	case simpleExpression:
+		if exp.operator.isList() {
+			return alternatives(exp.expanded())
+		}
+		return [][]simpleExpression{{exp}}, true
+	case complexExpression:
+		if exp.operator == loOr {
+			var out [][]simpleExpression
+			for _, child := range exp.expressions {
+				alts, ok := alternatives(child)
+				if !ok || len(out)+len(alts) > maxAlternatives {
+					return nil, false
+				}
+				out = append(out, alts...)
+			}
+			return out, true
+		}
+
+		out := [][]simpleExpression{{}}
+		for _, child := range exp.expressions {
+			alts, ok := alternatives(child)
+			if !ok || len(out)*len(alts) > maxAlternatives {
+				return nil, false
+			}
+
+			combined := make([][]simpleExpression, 0, len(out)*len(alts))
+			for _, prefix := range out {
+				for _, alt := range alts {
+					combined = append(combined, append(slices.Clip(prefix), alt...))
+				}
+			}
+			out = combined
+		}
+		return out, true
+	}
+
+	return nil, true
+}
+
+// conflicting checks if any two of clauses can't match the same log event
+func conflicting(clauses []simpleExpression) bool {
+	for i, a := range clauses {
+		for _, b := range clauses[i+1:] {
+			if clausesConflict(a, b) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// clausesConflict checks if a and b, over the same field, can't both match
+func clausesConflict(a, b simpleExpression) bool {
+	a, b = a.normalizedOperands().selectorFirst(), b.normalizedOperands().selectorFirst()
+	if a.left != b.left {
+		return false
+	}
+
+	if a.operator == coNotExists || b.operator == coNotExists {
+		// != also matches events without the field
+		return a.operator != b.operator && a.operator != coNotEqual && b.operator != coNotEqual
+	}
+
+	if b.operator == coEqual {
+		a, b = b, a
+	}
+
+	// numbers are compared by value, `1` and `1.0` being the same
+	valueA, valueB := canonicalizeNumber(unquoteWord(a.right)), canonicalizeNumber(unquoteWord(b.right))
+	switch {
+	case a.operator == coEqual && b.operator == coEqual:
+		return valueA != valueB && !strings.Contains(valueA, "*") && !strings.Contains(valueB, "*")
+	case a.operator == coEqual && b.operator == coNotEqual:
+		return valueA == valueB
+	}
+
+	if !isNumber(valueA) || !isNumber(valueB) {
+		return false
+	}
+
+	limitA, _ := strconv.ParseFloat(valueA, 64)
+	limitB, _ := strconv.ParseFloat(valueB, 64)
+
+	if a.operator == coEqual {
+		return !numericMatches(b.operator, limitA, limitB)
+	}
+
+	// both are thresholds, they conflict when the range they leave is empty
+	lower, upper := a, b
+	if lower.operator == coLessThan || lower.operator == coLessThanOrEqual {
+		lower, upper = b, a
+		limitA, limitB = limitB, limitA
+	}
+	if (lower.operator != coGreaterThan && lower.operator != coGreaterThanOrEqual) ||
+		(upper.operator != coLessThan && upper.operator != coLessThanOrEqual) {
+		return false
+	}
+
+	return limitA > limitB || (limitA == limitB && (lower.operator == coGreaterThan || upper.operator == coLessThan))
+}
+
+// numericMatches checks if the value v matches the clause `$.x operator limit`
+func numericMatches(operator comparisonOperator, v, limit float64) bool {
+	switch operator {
+	case coNotEqual:
+		return v != limit
+	case coGreaterThan:
+		return v > limit
+	case coGreaterThanOrEqual:
+		return v >= limit
+	case coLessThan:
+		return v < limit
+	case coLessThanOrEqual:
+		return v <= limit
+	}
+
+	return true
+}
+
+// Warning is a lint finding on a filter that is valid but likely a mistake
+type Warning struct {
+	Message string
+	// Span locates the group, or the duplicated clause, the warning is about in
+	// the analyzed filter
+	Span Span
+}
+
+// Analyze lints the filter s, warning about AND groups that can never match
+// because two of their clauses conflict, like `$.a = x && $.a = y` or
+// `$.a IN [x] && $.a != x`, and about each redundant clause of OR groups, like
+// the second `$.a = x` in `$.a IN [x, y] || $.a = x`. Values with a `*`
+// wildcard may match each other, so they never conflict.
+func Analyze(s string) ([]Warning, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return analyze(exp, []Warning{}), nil
+}
+
+func analyze(e Expression, warnings []Warning) []Warning {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return warnings
+	}
+
+	clauses := analyzedClauses(c)
+	for i, exp := range clauses {
+		switch c.operator {
+		case loOr:
+			if found, _ := findEquivalentPos(exp, clauses[:i], CompareOptions{}); found {
+				warnings = append(warnings, Warning{
+					Message: "duplicated clause `" + exp.String() + "`",
+					Span:    exp.Span(),
+				})
+			}
+		case loAnd:
+			for _, other := range clauses[i+1:] {
+				if areConflicting(exp, other) {
+					warnings = append(warnings, Warning{
+						Message: "clauses `" + exp.String() + "` and `" + other.String() + "` can't both match, the group never matches",
+						Span:    c.span,
+					})
+				}
+			}
+		}
+	}
+
+	for _, exp := range c.expressions {
+		warnings = analyze(exp, warnings)
+	}
+
+	return warnings
+}
+
+// analyzedClauses returns the expressions of c with the IN lists of OR groups
+// and the NOT IN lists of AND groups expanded, like withExpandedIn, but keeping
+// repeated values and locating each expanded clause at its list
+func analyzedClauses(c complexExpression) []Expression {
+	inlined, operator := coIn, coEqual
+	if c.operator == loAnd {
+		inlined, operator = coNotIn, coNotEqual
+	}
+
+	clauses := make([]Expression, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		s, ok := unwrapSingle(exp).(simpleExpression)
+		if !ok || s.operator != inlined {
+			clauses = append(clauses, exp)
+			continue
+		}
+
+		for _, v := range s.values {
+			clauses = append(clauses, simpleExpression{left: s.left, operator: operator, right: v, span: s.span})
+		}
+	}
+
+	return clauses
+}
+
+// areConflicting checks if the clauses a and b can't match the same log event,
+// whatever value of a list they match. See clausesConflict for how operands are
+// compared: `$.a = "x"` and `$.a = x` don't conflict.
+func areConflicting(a, b Expression) bool {
+	simpleA, okA := unwrapSingle(a).(simpleExpression)
+	simpleB, okB := unwrapSingle(b).(simpleExpression)
+	if !okA || !okB {
+		return false
+	}
+
+	altsA, okA := alternatives(simpleA)
+	altsB, okB := alternatives(simpleB)
+	if !okA || !okB {
+		return false
+	}
+
+	for _, altA := range altsA {
+		for _, altB := range altsB {
+			if !conflicting(append(slices.Clip(altA), altB...)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,387 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestIsTautology(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+		err error
+	}{
+		"simple expression": {
+			in:  "{ $.a = x }",
+			out: false,
+		},
+		"complementary or": {
+			in:  "{ $.a = x || $.a != x }",
+			out: true,
+		},
+		"complementary or with swapped operands": {
+			in:  "{ ($.a = x) || (x != $.a) }",
+			out: true,
+		},
+		"complementary or among other clauses": {
+			in:  "{ $.b = y || $.a != x || $.c = z || $.a = x }",
+			out: true,
+		},
+		"complementary and": {
+			in:  "{ $.a = x && $.a != x }",
+			out: false,
+		},
+		"different values": {
+			in:  "{ $.a = x || $.a != y }",
+			out: false,
+		},
+		"different fields": {
+			in:  "{ $.a = x || $.b != x }",
+			out: false,
+		},
+		"nested tautology in or": {
+			in:  "{ $.b = y || ($.a = x || $.a != x) }",
+			out: true,
+		},
+		"nested tautologies in and": {
+			in:  "{ ($.a = x || $.a != x) && ($.b = y || $.b != y) }",
+			out: true,
+		},
+		"nested tautology and clause": {
+			in:  "{ ($.a = x || $.a != x) && $.b = y }",
+			out: false,
+		},
+		"error on unparseable expression": {
+			in:  "{ $.a = x || $.a }",
+			err: errors.New("could not find a operator for this expression"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := IsTautology(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestIsContradiction(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+		err error
+	}{
+		"simple expression": {
+			in:  "{ $.a = x }",
+			out: false,
+		},
+		"complementary and": {
+			in:  "{ $.a = x && $.a != x }",
+			out: true,
+		},
+		"complementary and with swapped operands": {
+			in:  "{ (x = $.a) && ($.a != x) }",
+			out: true,
+		},
+		"complementary or": {
+			in:  "{ $.a = x || $.a != x }",
+			out: false,
+		},
+		"different values": {
+			in:  "{ $.a = x && $.a != y }",
+			out: false,
+		},
+		"nested contradiction in and": {
+			in:  "{ $.b = y && ($.a = x && $.a != x) }",
+			out: true,
+		},
+		"nested contradictions in or": {
+			in:  "{ ($.a = x && $.a != x) || ($.b = y && $.b != y) }",
+			out: true,
+		},
+		"nested contradiction or clause": {
+			in:  "{ ($.a = x && $.a != x) || $.b = y }",
+			out: false,
+		},
+		"error on unparseable expression": {
+			in:  "{ $.a = x && $.a }",
+			err: errors.New("could not find a operator for this expression"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := IsContradiction(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestSubsumes(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+		err error
+	}{
+		"same clause":                        {a: "{ $.x > 10 }", b: "{ $.x > 10 }", out: true},
+		"lower greater than threshold":       {a: "{ $.x > 5 }", b: "{ $.x > 10 }", out: true},
+		"higher greater than threshold":      {a: "{ $.x > 10 }", b: "{ $.x > 5 }", out: false},
+		"greater than against boundary":      {a: "{ $.x > 10 }", b: "{ $.x >= 10 }", out: false},
+		"greater or equal against boundary":  {a: "{ $.x >= 10 }", b: "{ $.x > 10 }", out: true},
+		"greater or equal same threshold":    {a: "{ $.x >= 10 }", b: "{ $.x >= 10 }", out: true},
+		"greater than just below":            {a: "{ $.x > 9.5 }", b: "{ $.x >= 10 }", out: true},
+		"higher less than threshold":         {a: "{ $.x < 10 }", b: "{ $.x < 5 }", out: true},
+		"lower less than threshold":          {a: "{ $.x < 5 }", b: "{ $.x < 10 }", out: false},
+		"less than against boundary":         {a: "{ $.x < 10 }", b: "{ $.x <= 10 }", out: false},
+		"less or equal against boundary":     {a: "{ $.x <= 10 }", b: "{ $.x < 10 }", out: true},
+		"negative thresholds":                {a: "{ $.x > -10 }", b: "{ $.x > -5 }", out: true},
+		"equal value above threshold":        {a: "{ $.x > 5 }", b: "{ $.x = 7 }", out: true},
+		"equal value at threshold":           {a: "{ $.x > 5 }", b: "{ $.x = 5 }", out: false},
+		"equal value at inclusive threshold": {a: "{ $.x >= 5 }", b: "{ $.x = 5 }", out: true},
+		"mirrored operands":                  {a: "{ 5 < $.x }", b: "{ $.x > 10 }", out: true},
+		"opposite directions":                {a: "{ $.x > 5 }", b: "{ $.x < 10 }", out: false},
+		"different selectors":                {a: "{ $.x > 5 }", b: "{ $.y > 10 }", out: false},
+		"not a number":                       {a: "{ $.x > a }", b: "{ $.x > b }", out: false},
+		"infinity words":                     {a: "{ $.x = Infinity }", b: "{ $.x = inf }", out: false},
+		"NaN words":                          {a: "{ $.x >= NaN }", b: "{ $.x = nan }", out: false},
+		"hexadecimal word":                   {a: "{ $.x > 10 }", b: "{ $.x = 0x1p4 }", out: false},
+		"and narrows":                        {a: "{ $.x > 5 }", b: "{ $.x > 10 && $.y = a }", out: true},
+		"or broadens":                        {a: "{ $.x > 5 || $.y = a }", b: "{ $.x > 10 }", out: true},
+		"every alternative subsumed":         {a: "{ $.x > 5 }", b: "{ $.x > 10 || $.x = 6 }", out: true},
+		"one alternative not subsumed":       {a: "{ $.x > 5 }", b: "{ $.x > 10 || $.x = 1 }", out: false},
+		"every condition of a holds":         {a: "{ $.x > 5 && $.x < 20 }", b: "{ $.x > 10 && $.x <= 15 }", out: true},
+		"one condition of a doesn't hold":    {a: "{ $.x > 5 && $.x < 20 }", b: "{ $.x > 10 && $.x <= 25 }", out: false},
+		"fewer conditions are broader":       {a: "{ $.a = b }", b: "{ $.a = b && $.c = d }", out: true},
+		"more conditions are narrower":       {a: "{ $.a = b && $.c = d }", b: "{ $.a = b }", out: false},
+		"IN list broadens":                   {a: "{ $.x IN [1, 2, 3] }", b: "{ $.x = 2 }", out: true},
+		"IN list subsumed by threshold":      {a: "{ $.x >= 1 }", b: "{ $.x IN [1, 2] }", out: true},
+		"IN list not subsumed by threshold":  {a: "{ $.x > 1 }", b: "{ $.x IN [1, 2] }", out: false},
+		"error on malformed filter":          {a: "{ $.x > 5 }", b: "{ ($.x > 10 }", err: errors.New("broken parenthesis")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Subsumes(tc.a, tc.b)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestIsStricterThan(t *testing.T) {
+	rootUsage := "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS }"
+
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+		err error
+	}{
+		"same filter":                    {a: rootUsage, b: rootUsage, out: true},
+		"clause superset":                {a: "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }", b: rootUsage, out: true},
+		"reordered clause superset":      {a: "{ $.eventType != \"AwsServiceEvent\" && $.userIdentity.invokedBy NOT EXISTS && \"Root\" = $.userIdentity.type }", b: rootUsage, out: true},
+		"clause subset":                  {a: rootUsage, b: "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }", out: false},
+		"superset of a single clause":    {a: rootUsage, b: "{ $.userIdentity.type = \"Root\" }", out: true},
+		"other clauses":                  {a: "{ $.a = b && $.c = d }", b: "{ $.a = b && $.e = f }", out: false},
+		"narrower numeric range":         {a: "{ $.bytes > 10 && $.bytes < 20 }", b: "{ $.bytes > 5 && $.bytes <= 20 }", out: true},
+		"wider numeric range":            {a: "{ $.bytes > 5 && $.bytes < 20 }", b: "{ $.bytes > 10 }", out: false},
+		"numeric range and other clause": {a: "{ $.status = 500 && $.bytes > 1024 }", b: "{ $.bytes >= 1024 }", out: true},
+		"error on OR filter":             {a: "{ $.a = b || $.c = d }", b: "{ $.a = b }", err: errors.New("expected an AND group of simple clauses")},
+		"error on nested group":          {a: "{ $.a = b }", b: "{ $.a = b && ($.c = d || $.e = f) }", err: errors.New("expected an AND group of simple clauses")},
+		"error on malformed filter":      {a: "{ $.a = b }", b: "{ ($.a = b }", err: errors.New("broken parenthesis")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := IsStricterThan(tc.a, tc.b)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	trails := "{ $.eventName = CreateTrail || $.eventName = UpdateTrail || $.eventName = DeleteTrail }"
+
+	// 2^18 alternatives, none matching $.a0 = z && $.b0 = z, too many to prove it
+	groups := make([]string, 0, 18)
+	for i := 0; i < 18; i++ {
+		groups = append(groups, fmt.Sprintf("($.a%d = x || $.b%d = y)", i, i))
+	}
+	manyAlternatives := "{ " + strings.Join(groups, " && ") + " }"
+
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+		err error
+	}{
+		"same filter":                   {a: trails, b: trails, out: true},
+		"shared event name":             {a: trails, b: "{ $.eventName = StopLogging || $.eventName = DeleteTrail }", out: true},
+		"disjoint event names":          {a: trails, b: "{ $.eventName = StopLogging || $.eventName = StartLogging }", out: false},
+		"shared event name in a list":   {a: trails, b: "{ $.eventName IN [StopLogging, \"UpdateTrail\"] }", out: true},
+		"disjoint lists":                {a: "{ $.eventName IN [a, b] }", b: "{ $.eventName IN [c, d] }", out: false},
+		"other fields":                  {a: "{ $.eventName = CreateTrail }", b: "{ $.eventSource = cloudtrail.amazonaws.com }", out: true},
+		"compatible AND groups":         {a: "{ $.eventSource = kms.amazonaws.com && $.eventName = DisableKey }", b: "{ $.eventName = DisableKey && $.errorCode NOT EXISTS }", out: true},
+		"conflicting AND groups":        {a: "{ $.eventSource = kms.amazonaws.com && $.eventName = DisableKey }", b: "{ $.eventSource = kms.amazonaws.com && $.eventName = ScheduleKeyDeletion }", out: false},
+		"negated value":                 {a: "{ $.eventName = ConsoleLogin }", b: "{ ConsoleLogin != $.eventName }", out: false},
+		"NOT IN the value":              {a: "{ $.eventName = ConsoleLogin }", b: "{ $.eventName NOT IN [ConsoleLogin, SwitchRole] }", out: false},
+		"NOT IN other values":           {a: "{ $.eventName = ConsoleLogin }", b: "{ $.eventName NOT IN [SwitchRole] }", out: true},
+		"value and NOT EXISTS":          {a: "{ $.errorCode = AccessDenied }", b: "{ $.errorCode NOT EXISTS }", out: false},
+		"negation and NOT EXISTS":       {a: "{ $.errorCode != AccessDenied }", b: "{ $.errorCode NOT EXISTS }", out: true},
+		"wildcard":                      {a: "{ $.errorCode = \"*UnauthorizedOperation\" }", b: "{ $.errorCode = AccessDenied }", out: true},
+		"overlapping numeric ranges":    {a: "{ $.bytes > 10 && $.bytes < 20 }", b: "{ $.bytes >= 15 }", out: true},
+		"disjoint numeric ranges":       {a: "{ $.bytes > 10 }", b: "{ $.bytes <= 10 }", out: false},
+		"number outside a range":        {a: "{ $.status = 200 }", b: "{ $.status >= 400 }", out: false},
+		"number inside a range":         {a: "{ $.status = 404 }", b: "{ $.status >= 400 }", out: true},
+		"nested groups":                 {a: "{ $.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion) }", b: "{ $.eventName = ScheduleKeyDeletion }", out: true},
+		"conflict in every alternative": {a: "{ ($.a = x || $.b = y) && $.c = z }", b: "{ $.c = w }", out: false},
+		"bracket notation selector":     {a: "{ $.eventName = ConsoleLogin }", b: `{ $["eventName"] = SwitchRole }`, out: false},
+		"same number":                   {a: "{ $.a = 1 }", b: "{ $.a = 1.0 }", out: true},
+		"same number negated":           {a: "{ $.a = 1 }", b: "{ $.a != 1e0 }", out: false},
+		"quoted numbers":                {a: "{ $.status = \"200\" }", b: "{ $.status >= 400 }", out: true},
+		"too many alternatives":         {a: manyAlternatives, b: "{ $.a0 = z && $.b0 = z }", out: true},
+		"error on malformed filter":     {a: "{ $.a = b }", b: "{ ($.a = b }", err: errors.New("broken parenthesis")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Overlaps(tc.a, tc.b)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+
+			out, err = Overlaps(tc.b, tc.a)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out []Warning
+		err error
+	}{
+		"no warnings": {
+			in:  "{ ($.eventSource = kms.amazonaws.com) && (($.eventName = DisableKey) || ($.eventName = ScheduleKeyDeletion)) }",
+			out: []Warning{},
+		},
+		"conflicting values": {
+			in: "{ $.eventName = CreateUser && $.eventName = DeleteUser }",
+			out: []Warning{
+				{Message: "clauses `$.eventName = CreateUser` and `$.eventName = DeleteUser` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 54}},
+			},
+		},
+		"conflicting values with swapped operands": {
+			in: "{ $.a = x && y = $.a }",
+			out: []Warning{
+				{Message: "clauses `$.a = x` and `y = $.a` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 20}},
+			},
+		},
+		"same value": {
+			in:  "{ $.a = x && x = $.a }",
+			out: []Warning{},
+		},
+		"same value with quotes": {
+			in:  "{ $.a = \"x\" && $.a = x }",
+			out: []Warning{},
+		},
+		"conflicting values with bracket selectors": {
+			in: "{ $[\"a\"] = x && $.a = \"y\" }",
+			out: []Warning{
+				{Message: "clauses `$[\"a\"] = x` and `$.a = \"y\"` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 25}},
+			},
+		},
+		"different fields": {
+			in:  "{ $.a = x && $.b = y }",
+			out: []Warning{},
+		},
+		"different operators": {
+			in:  "{ $.a = x && $.a != y }",
+			out: []Warning{},
+		},
+		"wildcard values": {
+			in:  "{ $.a = \"x*\" && $.a = \"xy\" }",
+			out: []Warning{},
+		},
+		"conflicting values in OR": {
+			in:  "{ $.a = x || $.a = y }",
+			out: []Warning{},
+		},
+		"duplicated clause": {
+			in: "{ $.a = x || $.b = y || x = $.a }",
+			out: []Warning{
+				{Message: "duplicated clause `x = $.a`", Span: Span{StartByte: 24, EndByte: 31}},
+			},
+		},
+		"clause duplicated twice": {
+			in: "{ $.a = x || $.a = x || $.a = x }",
+			out: []Warning{
+				{Message: "duplicated clause `$.a = x`", Span: Span{StartByte: 13, EndByte: 20}},
+				{Message: "duplicated clause `$.a = x`", Span: Span{StartByte: 24, EndByte: 31}},
+			},
+		},
+		"clause duplicated in list": {
+			in: "{ $.a IN [x, y] || $.a = x }",
+			out: []Warning{
+				{Message: "duplicated clause `$.a = x`", Span: Span{StartByte: 19, EndByte: 26}},
+			},
+		},
+		"value duplicated in list": {
+			in:  "{ $.a IN [x, y, x] }",
+			out: []Warning{},
+		},
+		"list conflicting with clause": {
+			in: "{ $.a IN [x] && $.a != x }",
+			out: []Warning{
+				{Message: "clauses `$.a IN [x]` and `$.a != x` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 24}},
+			},
+		},
+		"clause conflicting with excluded value": {
+			in: "{ $.a = x && $.a NOT IN [y, x] }",
+			out: []Warning{
+				{Message: "clauses `$.a = x` and `$.a != x` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 30}},
+			},
+		},
+		"list conflicting with each value": {
+			in: "{ $.a IN [x, y] && $.a = z }",
+			out: []Warning{
+				{Message: "clauses `$.a IN [x, y]` and `$.a = z` can't both match, the group never matches", Span: Span{StartByte: 2, EndByte: 26}},
+			},
+		},
+		"list with a matching value": {
+			in:  "{ $.a IN [x, y] && $.a != x }",
+			out: []Warning{},
+		},
+		"duplicated clause in AND": {
+			in:  "{ $.a = x && $.a = x }",
+			out: []Warning{},
+		},
+		"nested groups": {
+			in: "{ $.c = d || ($.a = x && ($.b = y || $.b = y) && $.a = z) }",
+			out: []Warning{
+				{Message: "clauses `$.a = x` and `$.a = z` can't both match, the group never matches", Span: Span{StartByte: 14, EndByte: 56}},
+				{Message: "duplicated clause `$.b = y`", Span: Span{StartByte: 37, EndByte: 44}},
+			},
+		},
+		"error on malformed filter": {
+			in:  "{ ($.a = x }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Analyze(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package cloudwatch_lep
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseAll parses each input independently, without stopping at the first
+// failure. Both returned slices are aligned with inputs: for each index either
+// the expression or the error is set.
+func ParseAll(inputs []string) ([]Expression, []error) {
+	expressions := make([]Expression, len(inputs))
+	errs := make([]error, len(inputs))
+	for i, in := range inputs {
+		expressions[i], errs[i] = parse(in)
+	}
+
+	return expressions, errs
+}
+
+// ParsedResult is the outcome of parsing a filter read by a FilterScanner:
+// either the expression or the error is set
+type ParsedResult struct {
+	// Line is the 1-based line number of the filter in the input
+	Line       int
+	Input      string
+	Expression Expression
+	Err        error
+}
+
+// FilterScanner reads newline-delimited filters from an io.Reader and parses
+// them one by one, so big inventories of filters don't need to be loaded at
+// once. Blank lines and lines starting with `#` are skipped. A filter that
+// fails to parse doesn't stop the scan, its error is part of its result, and
+// neither does a line too long to be a filter, reported with ErrInputTooLarge.
+type FilterScanner struct {
+	reader *bufio.Reader
+	line   int
+	result ParsedResult
+	err    error
+}
+
+// maxFilterLineBytes is how long a line read by a FilterScanner can be: runes
+// are up to 4 bytes, so longer lines fail to parse anyway
+const maxFilterLineBytes = 4 * maxInputLength
+
+// NewFilterScanner returns a FilterScanner reading filters from r
+func NewFilterScanner(r io.Reader) *FilterScanner {
+	return &FilterScanner{reader: bufio.NewReader(r)}
+}
+
+// Scan parses the next filter, available through Result. It returns false at
+// the end of the input or when reading fails, see Err.
+func (s *FilterScanner) Scan() bool {
+	for {
+		line, ok, err := s.readLine()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+
+			s.result = ParsedResult{}
+			return false
+		}
+
+		s.line++
+		if !ok {
+			s.result = ParsedResult{Line: s.line, Err: ErrInputTooLarge}
+			return true
+		}
+
+		input := strings.TrimSpace(line)
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue
+		}
+
+		exp, err := parse(input)
+		s.result = ParsedResult{Line: s.line, Input: input, Expression: exp, Err: err}
+		return true
+	}
+}
+
+// readLine returns the next line without its line ending, or false when it's
+// longer than maxFilterLineBytes, the line being skipped
+func (s *FilterScanner) readLine() (string, bool, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, isPrefix, err := s.reader.ReadLine()
+		if err != nil {
+			return "", false, err
+		}
+
+		if len(line)+len(chunk) > maxFilterLineBytes {
+			line, tooLong = nil, true
+		} else if !tooLong {
+			line = append(line, chunk...)
+		}
+
+		if !isPrefix {
+			return string(line), !tooLong, nil
+		}
+	}
+}
+
+// Result returns the filter parsed by the last call to Scan
+func (s *FilterScanner) Result() ParsedResult {
+	return s.result
+}
+
+// Err returns the error that stopped reading the input, if any. Parse errors
+// are reported by Result instead.
+func (s *FilterScanner) Err() error {
+	return s.err
+}
+
+// MultiError aggregates the errors of a batch, indexed like its inputs. Inputs
+// that succeeded have a nil entry.
+type MultiError []error
+
+// Err returns m as an error if any entry failed, otherwise nil.
+func (m MultiError) Err() error {
+	for _, err := range m {
+		if err != nil {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func (m MultiError) Error() string {
+	failed := m.Unwrap()
+	parts := make([]string, 0, len(failed))
+	for i, err := range m {
+		if err != nil {
+			parts = append(parts, "["+strconv.Itoa(i)+"] "+err.Error())
+		}
+	}
+
+	return strconv.Itoa(len(failed)) + " of " + strconv.Itoa(len(m)) + " inputs failed: " + strings.Join(parts, "; ")
+}
+
+// Unwrap returns the non-nil errors, so errors.Is and errors.As inspect them.
+func (m MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(m))
+	for _, err := range m {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
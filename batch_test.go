@@ -0,0 +1,127 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseAll(t *testing.T) {
+	inputs := []string{
+		"{ $.eventName = ConsoleLogin }",
+		"{ ($.eventName = ConsoleLogin }",
+		"{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+		"{ $.a = b && $.c = d || $.e = f }",
+	}
+
+	expressions, errs := ParseAll(inputs)
+	require.Len(t, expressions, len(inputs))
+	require.Len(t, errs, len(inputs))
+
+	require.NoError(t, errs[0])
+	require.Equal(t, se("$.eventName", coEqual, "ConsoleLogin"), withoutSpans(expressions[0]))
+
+	require.Equal(t, errors.New("broken parenthesis"), errs[1])
+	require.Nil(t, expressions[1])
+
+	require.NoError(t, errs[2])
+	require.Equal(t, ce("&&",
+		se("$.eventSource", coEqual, "kms.amazonaws.com"),
+		ce("||",
+			se("$.eventName", coEqual, "DisableKey"),
+			se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+		),
+	), withoutSpans(expressions[2]))
+
+	require.Equal(t, ErrAlternatingLogicalOperators, errs[3])
+	require.Nil(t, expressions[3])
+
+	err := MultiError(errs).Err()
+	require.EqualError(t, err, "2 of 4 inputs failed: [1] broken parenthesis; [3] not supported comparison with alternating logical operators")
+	require.ErrorIs(t, err, ErrAlternatingLogicalOperators)
+}
+
+func TestParseAll_noErrors(t *testing.T) {
+	expressions, errs := ParseAll([]string{"{ $.a = b }", "{ $.c != d }"})
+	require.Equal(t, []Expression{se("$.a", coEqual, "b"), se("$.c", coNotEqual, "d")}, []Expression{withoutSpans(expressions[0]), withoutSpans(expressions[1])})
+	require.NoError(t, MultiError(errs).Err())
+}
+
+func TestParseAll_empty(t *testing.T) {
+	expressions, errs := ParseAll(nil)
+	require.Empty(t, expressions)
+	require.NoError(t, MultiError(errs).Err())
+}
+
+func TestFilterScanner(t *testing.T) {
+	input := "{ $.eventName = ConsoleLogin }\n" +
+		"\n" +
+		"# KMS keys\r\n" +
+		"  { ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }  \n" +
+		"{ ($.eventName = ConsoleLogin }\n" +
+		"{ $.a = b && $.c = d || $.e = f }\n" +
+		"{ $.errorCode = \"AccessDenied\" }"
+
+	scanner := NewFilterScanner(strings.NewReader(input))
+	var results []ParsedResult
+	for scanner.Scan() {
+		results = append(results, scanner.Result())
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, ParsedResult{}, scanner.Result())
+
+	require.Len(t, results, 5)
+	lines := make([]int, len(results))
+	for i, result := range results {
+		lines[i] = result.Line
+	}
+	require.Equal(t, []int{1, 4, 5, 6, 7}, lines)
+
+	require.NoError(t, results[0].Err)
+	require.Equal(t, se("$.eventName", coEqual, "ConsoleLogin"), withoutSpans(results[0].Expression))
+
+	require.NoError(t, results[1].Err)
+	require.Equal(t, "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }", results[1].Input)
+	require.Equal(t, "$.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion)", results[1].Expression.String())
+
+	// malformed lines don't stop the scan
+	require.Equal(t, errors.New("broken parenthesis"), results[2].Err)
+	require.Nil(t, results[2].Expression)
+	require.Equal(t, ErrAlternatingLogicalOperators, results[3].Err)
+
+	require.NoError(t, results[4].Err)
+	require.Equal(t, se("$.errorCode", coEqual, "\"AccessDenied\""), withoutSpans(results[4].Expression))
+}
+
+func TestFilterScanner_longLine(t *testing.T) {
+	input := "{ $.a = b }\n" +
+		"{ $.a = " + strings.Repeat("b", 300_000) + " }\n" +
+		"{ $.c = d }"
+
+	scanner := NewFilterScanner(strings.NewReader(input))
+	var results []ParsedResult
+	for scanner.Scan() {
+		results = append(results, scanner.Result())
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, ParsedResult{Line: 2, Err: ErrInputTooLarge}, results[1])
+	require.NoError(t, results[2].Err)
+	require.Equal(t, 3, results[2].Line)
+	require.Equal(t, se("$.c", coEqual, "d"), withoutSpans(results[2].Expression))
+}
+
+func TestFilterScanner_readError(t *testing.T) {
+	failure := errors.New("connection reset")
+	scanner := NewFilterScanner(io.MultiReader(strings.NewReader("{ $.a = b }\n"), iotest.ErrReader(failure)))
+
+	require.True(t, scanner.Scan())
+	require.NoError(t, scanner.Result().Err)
+	require.False(t, scanner.Scan())
+	require.Equal(t, failure, scanner.Err())
+}
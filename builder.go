@@ -0,0 +1,56 @@
+package cloudwatch_lep
+
+import (
+	"slices"
+	"strconv"
+)
+
+// NewSimple builds the clause `left op right`, the same parsing it would give.
+// The right operand of IN and NOT IN is the list as written, like `[a, "b"]`,
+// and it's empty for NOT EXISTS.
+//
+// It panics if op isn't a known comparison operator or the operands don't fit
+// it, like a right operand holding another clause or wrapped in parenthesis the
+// parsing would drop, as builders are meant for filters written in code.
+func NewSimple(left string, op ComparisonOperator, right string) Expression {
+	if !slices.Contains(listComparisonOperator(), op) {
+		panic("cloudwatch_lep: unknown comparison operator " + strconv.Quote(string(op)))
+	}
+
+	if right == "" && op != coNotExists {
+		panic("cloudwatch_lep: missing right operand for " + string(op))
+	}
+
+	written := left + " " + string(op)
+	if right != "" {
+		written += " " + right
+	}
+
+	exp, err := parse("{ " + written + " }")
+	if err != nil {
+		panic("cloudwatch_lep: " + err.Error() + " in " + strconv.Quote(written))
+	}
+
+	s, ok := exp.(simpleExpression)
+	if !ok || s.left != left || s.operator != op || (!op.isList() && s.right != right) {
+		panic("cloudwatch_lep: " + strconv.Quote(written) + " doesn't parse as a clause of these operands")
+	}
+
+	s.span = Span{} // built, not parsed from a filter
+	return s
+}
+
+// NewComplex builds the group joining children with op.
+//
+// It panics if op isn't a logical operator or there are no children.
+func NewComplex(op LogicalOperator, children ...Expression) Expression {
+	if !slices.Contains(listLogicalOperators(), op) {
+		panic("cloudwatch_lep: unknown logical operator " + strconv.Quote(string(op)))
+	}
+
+	if len(children) == 0 || slices.Contains(children, nil) {
+		panic("cloudwatch_lep: missing expressions for " + string(op))
+	}
+
+	return complexExpression{operator: op, expressions: slices.Clone(children)}
+}
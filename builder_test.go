@@ -0,0 +1,63 @@
+package cloudwatch_lep
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewSimple(t *testing.T) {
+	require.Equal(t, se("$.a", coEqual, "b"), NewSimple("$.a", Equal, "b"))
+	require.Equal(t, se("$.a", coNotExists, ""), NewSimple("$.a", NotExists, ""))
+	require.Equal(t, sin("$.a", "b", "\"c d\""), NewSimple("$.a", In, "[b, \"c d\"]"))
+	require.Equal(t, snotin("$.a", "b"), NewSimple("$.a", NotIn, "[b]"))
+
+	panics := map[string]func(){
+		"unknown operator":      func() { NewSimple("$.a", "<>", "b") },
+		"missing left operand":  func() { NewSimple("", Equal, "b") },
+		"missing right operand": func() { NewSimple("$.a", Equal, "") },
+		"value after NOT EXISTS": func() {
+			NewSimple("$.a", NotExists, "b")
+		},
+		"not a list":            func() { NewSimple("$.a", In, "b") },
+		"other clause in value": func() { NewSimple("$.a", Equal, "b && $.c = d") },
+		"parenthesized value":   func() { NewSimple("$.a", Equal, "(b)") },
+		"list after the list":   func() { NewSimple("$.a", In, "[b] || $.c IN [d]") },
+	}
+
+	for name, f := range panics {
+		t.Run(name, func(t *testing.T) {
+			require.Panics(t, f)
+		})
+	}
+}
+
+func TestNewComplex(t *testing.T) {
+	a, b := NewSimple("$.a", Equal, "b"), NewSimple("$.c", LessThan, "1")
+	require.Equal(t, ce(loAnd, se("$.a", coEqual, "b"), se("$.c", coLessThan, "1")), NewComplex(And, a, b))
+
+	require.Panics(t, func() { NewComplex("AND", a, b) })
+	require.Panics(t, func() { NewComplex(Or) })
+	require.Panics(t, func() { NewComplex(Or, a, nil) })
+}
+
+func ExampleNewComplex() {
+	built := NewComplex(And,
+		NewSimple("$.eventSource", Equal, "kms.amazonaws.com"),
+		NewComplex(Or,
+			NewSimple("$.eventName", Equal, "DisableKey"),
+			NewSimple("$.eventName", Equal, "ScheduleKeyDeletion"),
+		),
+	)
+
+	parsed, err := Parse("{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(built)
+	fmt.Println(built.Equals(parsed))
+	// Output:
+	// $.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion)
+	// true
+}
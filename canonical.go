@@ -0,0 +1,263 @@
+package cloudwatch_lep
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ChangeKind classifies a change made to a filter to canonicalize it
+type ChangeKind int
+
+const (
+	// CollapsedWhitespace is spacing other than a single space around
+	// operators and inside braces, like `$.a=b` or `$.a  =  b`
+	CollapsedWhitespace ChangeKind = iota
+	// RemovedParenthesis is a pair of parenthesis that groups nothing, like the
+	// ones around a clause or doubled around a group
+	RemovedParenthesis
+	// StrippedQuotes is a quoted value that means the same without quotes
+	StrippedQuotes
+	// SwappedOperands is a clause written value first, like `b = $.a`
+	SwappedOperands
+	// ReplacedOperatorAlias is an operator written with an alias, like `<>`
+	ReplacedOperatorAlias
+	// ReorderedClauses is a group whose clauses, or a list whose values, aren't
+	// sorted
+	ReorderedClauses
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case CollapsedWhitespace:
+		return "CollapsedWhitespace"
+	case RemovedParenthesis:
+		return "RemovedParenthesis"
+	case StrippedQuotes:
+		return "StrippedQuotes"
+	case SwappedOperands:
+		return "SwappedOperands"
+	case ReplacedOperatorAlias:
+		return "ReplacedOperatorAlias"
+	case ReorderedClauses:
+		return "ReorderedClauses"
+	}
+
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Change is a transformation made to canonicalize a filter, Span locates what
+// it affects in the original filter.
+type Change struct {
+	Kind        ChangeKind
+	Description string
+	Span        Span
+}
+
+// Canonicalize parses s and renders it in canonical form: wrapped in braces,
+// with single spaces around operators, parenthesis only around sub groups,
+// selectors first, quotes only where needed and the clauses of each group,
+// like the values of IN lists, sorted. Filters that only differ by those have
+// the same canonical form. As quotes are stripped, it's equivalent to s when
+// comparing with QuoteInsensitiveValues, while the default comparison tells
+// `"b"` and `b` apart.
+func Canonicalize(s string) (string, error) {
+	out, _, err := CanonicalizeWithReport(s)
+	return out, err
+}
+
+// CanonicalizeWithReport is Canonicalize, also listing what was changed in s
+// sorted by where it happens. A filter already in canonical form has no
+// changes.
+func CanonicalizeWithReport(s string) (string, []Change, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c := canonicalizer{src: s}
+	out := c.canonical(exp, 0)
+	c.checkBraces(exp.Span())
+
+	sort.SliceStable(c.changes, func(i, j int) bool {
+		return c.changes[i].Span.StartByte < c.changes[j].Span.StartByte
+	})
+
+	return "{ " + out.String() + " }", c.changes, nil
+}
+
+// canonicalizer rewrites expressions parsed from src in canonical form,
+// recording the changes it makes
+type canonicalizer struct {
+	src     string
+	changes []Change
+}
+
+func (c *canonicalizer) add(kind ChangeKind, span Span, description string) {
+	c.changes = append(c.changes, Change{Kind: kind, Description: description, Span: span})
+}
+
+// canonical returns e in canonical form, e being wrapped in wantParenthesis
+// pairs of parenthesis when rendered
+func (c *canonicalizer) canonical(e Expression, wantParenthesis int) Expression {
+	c.checkParenthesis(e.Span(), wantParenthesis)
+
+	switch exp := e.(type) {
+	case simpleExpression:
+		return c.canonicalClause(exp)
+	case complexExpression:
+		return c.canonicalGroup(exp)
+	}
+
+	return e
+}
+
+func (c *canonicalizer) canonicalClause(s simpleExpression) Expression {
+	written := c.src[s.span.StartByte:s.span.EndByte]
+	pos, length, _ := findComparisonOp(written)
+	left, operator, right := strings.TrimSpace(written[:pos]), written[pos:pos+length], strings.TrimSpace(written[pos+length:])
+	if withoutSpaces(operator) != withoutSpaces(string(s.operator)) {
+		c.add(ReplacedOperatorAlias, s.span, "replaced the operator alias "+operator+" by "+string(s.operator))
+	}
+
+	spaced := left + " " + strings.Join(strings.Fields(operator), " ")
+	if s.operator != coNotExists {
+		spaced += " " + right
+	}
+
+	if unwrapped, _ := unwrapOperand(left, maxDepth); unwrapped != left {
+		c.add(RemovedParenthesis, Span{StartByte: s.span.StartByte, EndByte: s.span.StartByte + len(left)}, "removed the parenthesis around the value "+unwrapped)
+	}
+
+	if unwrapped, _ := unwrapOperand(right, maxDepth); unwrapped != right {
+		c.add(RemovedParenthesis, Span{StartByte: s.span.EndByte - len(right), EndByte: s.span.EndByte}, "removed the parenthesis around the value "+unwrapped)
+		right = unwrapped
+	}
+
+	if written != spaced || (s.operator.isList() && right != "["+strings.Join(s.values, ", ")+"]") {
+		c.add(CollapsedWhitespace, s.span, "collapsed the spacing of "+strconv.Quote(written))
+	}
+
+	if swapped := s.selectorFirst(); swapped.left != s.left {
+		c.add(SwappedOperands, s.span, "moved the selector "+swapped.left+" first")
+		swapped.span = s.span
+		s = swapped
+	}
+
+	if s.operator.isList() {
+		values := make([]string, len(s.values))
+		for i, v := range s.values {
+			values[i] = c.unquoted(s.span, v)
+		}
+
+		sorted := slices.Clone(values)
+		slices.Sort(sorted)
+		if !slices.Equal(values, sorted) {
+			c.add(ReorderedClauses, s.span, "sorted the values of the list")
+			values = sorted
+		}
+
+		return simpleExpression{left: s.left, operator: s.operator, values: values}
+	}
+
+	return simpleExpression{left: s.left, operator: s.operator, right: c.unquoted(s.span, s.right)}
+}
+
+// unquoted returns the value v of the clause at span without quotes, when it
+// means the same without them
+func (c *canonicalizer) unquoted(span Span, v string) string {
+	unquoted := unquoteWord(v)
+	if unquoted != v {
+		c.add(StrippedQuotes, operandSpan(c.src, span, v), "stripped the quotes of "+v)
+	}
+
+	return unquoted
+}
+
+func (c *canonicalizer) canonicalGroup(group complexExpression) Expression {
+	separator := " " + string(group.operator) + " "
+	expressions := make([]Expression, 0, len(group.expressions))
+	for i, exp := range group.expressions {
+		wantParenthesis := 0
+		if _, ok := exp.(complexExpression); ok {
+			wantParenthesis = 1
+		}
+		expressions = append(expressions, c.canonical(exp, wantParenthesis))
+
+		if i == 0 {
+			continue
+		}
+
+		// the parenthesis between clauses are already checked
+		start, end := group.expressions[i-1].Span().EndByte, exp.Span().StartByte
+		if between := strings.NewReplacer("(", "", ")", "").Replace(c.src[start:end]); between != separator {
+			c.add(CollapsedWhitespace, Span{StartByte: start, EndByte: end}, "collapsed the spacing around "+string(group.operator))
+		}
+	}
+
+	sorted := slices.Clone(expressions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+	if !slices.EqualFunc(expressions, sorted, func(a, b Expression) bool { return a.String() == b.String() }) {
+		c.add(ReorderedClauses, group.span, "sorted the clauses joined by "+string(group.operator))
+	}
+
+	return complexExpression{operator: group.operator, expressions: sorted}
+}
+
+// checkParenthesis records the pairs of parenthesis wrapping span beyond the
+// wanted ones
+func (c *canonicalizer) checkParenthesis(span Span, want int) {
+	start, end := span.StartByte, span.EndByte
+	found := 0
+	for {
+		before := strings.TrimRightFunc(c.src[:start], unicode.IsSpace)
+		after := strings.TrimLeftFunc(c.src[end:], unicode.IsSpace)
+		if !strings.HasSuffix(before, "(") || !strings.HasPrefix(after, ")") {
+			break
+		}
+
+		start, end = len(before)-1, len(c.src)-len(after)+1
+		found++
+	}
+
+	if found > want {
+		c.add(RemovedParenthesis, Span{StartByte: start, EndByte: end}, "removed "+strconv.Itoa(found-want)+" redundant pair(s) of parenthesis")
+	}
+}
+
+// checkBraces records the spacing inside the braces around the expression at
+// span other than a single space
+func (c *canonicalizer) checkBraces(span Span) {
+	noParenthesis := strings.NewReplacer("(", "", ")", "")
+	before, after := noParenthesis.Replace(c.src[:span.StartByte]), noParenthesis.Replace(c.src[span.EndByte:])
+	if strings.TrimSpace(before) != "{" || strings.TrimSpace(after) != "}" {
+		return // no braces to space
+	}
+
+	if before != "{ " {
+		c.add(CollapsedWhitespace, Span{StartByte: 0, EndByte: span.StartByte}, "collapsed the spacing after {")
+	}
+
+	if after != " }" {
+		c.add(CollapsedWhitespace, Span{StartByte: span.EndByte, EndByte: len(c.src)}, "collapsed the spacing before }")
+	}
+}
+
+// withoutSpaces removes the white space of s outside quoted strings
+func withoutSpaces(s string) string {
+	var b strings.Builder
+	quotes := quoteState{}
+	for i, r := range s {
+		if !quotes.next(s[i]) && unicode.IsSpace(r) {
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
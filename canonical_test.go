@@ -0,0 +1,205 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestCanonicalizeWithReport(t *testing.T) {
+	type change struct {
+		kind    ChangeKind
+		written string // the text of the original filter at the span
+	}
+
+	cases := map[string]struct {
+		in      string
+		out     string
+		changes []change
+		err     error
+	}{
+		"canonical": {
+			in:  "{ ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion) && $.eventSource = kms.amazonaws.com }",
+			out: "{ ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion) && $.eventSource = kms.amazonaws.com }",
+		},
+		"collapsed whitespace": {
+			in:  "{$.a   =b  &&\t$.c NOT  EXISTS }",
+			out: "{ $.a = b && $.c NOT EXISTS }",
+			changes: []change{
+				{CollapsedWhitespace, "{"},
+				{CollapsedWhitespace, "$.a   =b"},
+				{CollapsedWhitespace, "  &&\t"},
+				{CollapsedWhitespace, "$.c NOT  EXISTS"},
+			},
+		},
+		"removed redundant parenthesis": {
+			in:  "{ (($.a = b)) && ((($.c = d || $.e = f))) }",
+			out: "{ $.a = b && ($.c = d || $.e = f) }",
+			changes: []change{
+				{RemovedParenthesis, "(($.a = b))"},
+				{RemovedParenthesis, "((($.c = d || $.e = f)))"},
+			},
+		},
+		"stripped quotes": {
+			in:  "{ $.a = \"b\" && $.c = \"d e\" && $.f IN [\"g\", h] && $.i = \"IN\" }",
+			out: "{ $.a = b && $.c = \"d e\" && $.f IN [g, h] && $.i = \"IN\" }",
+			changes: []change{
+				{StrippedQuotes, "\"b\""},
+				{StrippedQuotes, "\"g\""},
+			},
+		},
+		"quoted selector": {
+			in:  "{ $.a = \"$.b\" || 0 = \"$\" }",
+			out: "{ $.a = \"$.b\" || 0 = \"$\" }",
+		},
+		"quoted numbers": {
+			in:  "{ $.a = \"200\" || $.a = \"b\" }",
+			out: "{ $.a = \"200\" || $.a = b }",
+			changes: []change{
+				{StrippedQuotes, "\"b\""},
+			},
+		},
+		"parenthesized value": {
+			in:  "{ $.a = (b) && $.c IN [d,e] && (f) = $.g }",
+			out: "{ $.a = b && $.c IN [d, e] && $.g = f }",
+			changes: []change{
+				{RemovedParenthesis, "(b)"},
+				{CollapsedWhitespace, "$.c IN [d,e]"},
+				{RemovedParenthesis, "(f)"},
+				{SwappedOperands, "(f) = $.g"},
+			},
+		},
+		"swapped operands": {
+			in:  "{ 10 > $.a }",
+			out: "{ $.a < 10 }",
+			changes: []change{
+				{SwappedOperands, "10 > $.a"},
+			},
+		},
+		"replaced operator alias": {
+			in:  "{ $.a <> b }",
+			out: "{ $.a != b }",
+			changes: []change{
+				{ReplacedOperatorAlias, "$.a <> b"},
+			},
+		},
+		"reordered clauses and values": {
+			in:  "{ $.c = d && $.a IN [z, y] }",
+			out: "{ $.a IN [y, z] && $.c = d }",
+			changes: []change{
+				{ReorderedClauses, "$.c = d && $.a IN [z, y]"},
+				{ReorderedClauses, "$.a IN [z, y]"},
+			},
+		},
+		"CIS KMS filter": {
+			in:  "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: "{ ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion) && $.eventSource = kms.amazonaws.com }",
+			changes: []change{
+				{CollapsedWhitespace, "{"},
+				{RemovedParenthesis, "($.eventSource = kms.amazonaws.com)"},
+				{ReorderedClauses, "($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion))"},
+				{RemovedParenthesis, "($.eventName=DisableKey)"},
+				{CollapsedWhitespace, "$.eventName=DisableKey"},
+				{CollapsedWhitespace, ")||("},
+				{RemovedParenthesis, "($.eventName=ScheduleKeyDeletion)"},
+				{CollapsedWhitespace, "$.eventName=ScheduleKeyDeletion"},
+			},
+		},
+		"error on malformed filter": {
+			in:  "{ ($.a = b }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, changes, err := CanonicalizeWithReport(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+
+			var written []change
+			for _, c := range changes {
+				require.NotEmpty(t, c.Description)
+				written = append(written, change{c.Kind, tc.in[c.Span.StartByte:c.Span.EndByte]})
+			}
+			require.Equal(t, tc.changes, written)
+
+			if err == nil {
+				equivalent, err := EquivalentWithOptions(out, tc.in, CompareOptions{QuoteInsensitiveValues: true})
+				require.NoError(t, err)
+				require.True(t, equivalent)
+
+				canonical, err := Canonicalize(tc.in)
+				require.NoError(t, err)
+				require.Equal(t, out, canonical)
+			}
+		})
+	}
+}
+
+func TestCanonicalize_metricFilters(t *testing.T) {
+	data, err := os.ReadFile("testdata/describe-metric-filters.json")
+	require.NoError(t, err)
+
+	expressions, _ := ParseMetricFilters(data)
+	require.NotEmpty(t, expressions)
+
+	for name, exp := range expressions {
+		t.Run(name, func(t *testing.T) {
+			out, err := Canonicalize(exp.String())
+			require.NoError(t, err)
+
+			// quotes are stripped as WithNormalizeQuotes does
+			normalized, err := Parse(exp.String(), WithNormalizeQuotes())
+			require.NoError(t, err)
+			canonical, err := parse(out)
+			require.NoError(t, err)
+			require.True(t, normalized.Equals(canonical))
+
+			equivalent, err := EquivalentWithOptions(out, exp.String(), CompareOptions{QuoteInsensitiveValues: true})
+			require.NoError(t, err)
+			require.True(t, equivalent)
+
+			again, changes, err := CanonicalizeWithReport(out)
+			require.NoError(t, err)
+			require.Equal(t, out, again)
+			require.Empty(t, changes)
+		})
+	}
+}
+
+func TestChangeKind_String(t *testing.T) {
+	require.Equal(t, "RemovedParenthesis", RemovedParenthesis.String())
+	require.Equal(t, "ChangeKind(42)", ChangeKind(42).String())
+}
+
+func TestCanonicalize_eventNameSet(t *testing.T) {
+	iamPolicyChanges := "{($.eventName=DeleteGroupPolicy)||($.eventName=DeleteRolePolicy)||($.eventName=DeleteUserPolicy)||($.eventName=PutGroupPolicy)||($.eventName=PutRolePolicy)||($.eventName=PutUserPolicy)||($.eventName=CreatePolicy)||($.eventName=DeletePolicy)||($.eventName=CreatePolicyVersion)||($.eventName=DeletePolicyVersion)||($.eventName=AttachRolePolicy)||($.eventName=DetachRolePolicy)||($.eventName=AttachUserPolicy)||($.eventName=DetachUserPolicy)||($.eventName=AttachGroupPolicy)||($.eventName=DetachGroupPolicy)}"
+	sorted := "{ $.eventName = AttachGroupPolicy || $.eventName = AttachRolePolicy || $.eventName = AttachUserPolicy || " +
+		"$.eventName = CreatePolicy || $.eventName = CreatePolicyVersion || $.eventName = DeleteGroupPolicy || " +
+		"$.eventName = DeletePolicy || $.eventName = DeletePolicyVersion || $.eventName = DeleteRolePolicy || " +
+		"$.eventName = DeleteUserPolicy || $.eventName = DetachGroupPolicy || $.eventName = DetachRolePolicy || " +
+		"$.eventName = DetachUserPolicy || $.eventName = PutGroupPolicy || $.eventName = PutRolePolicy || $.eventName = PutUserPolicy }"
+
+	out, changes, err := CanonicalizeWithReport(iamPolicyChanges)
+	require.NoError(t, err)
+	require.Equal(t, sorted, out)
+	require.Contains(t, changes, Change{Kind: ReorderedClauses, Description: "sorted the clauses joined by ||", Span: Span{StartByte: 1, EndByte: len(iamPolicyChanges) - 1}})
+
+	// the same set written in any order, with values first or quoted, has the
+	// same canonical form
+	shuffled := "{ \"PutUserPolicy\" = $.eventName || $.eventName = AttachGroupPolicy || $.eventName = DetachUserPolicy || " +
+		"$.eventName = DeletePolicy || $.eventName = PutRolePolicy || $.eventName = CreatePolicyVersion || " +
+		"$.eventName = DeleteRolePolicy || $.eventName = AttachUserPolicy || $.eventName = DeleteGroupPolicy || " +
+		"$.eventName = DetachRolePolicy || $.eventName = CreatePolicy || $.eventName = DeletePolicyVersion || " +
+		"$.eventName = PutGroupPolicy || $.eventName = DetachGroupPolicy || $.eventName = AttachRolePolicy || $.eventName = DeleteUserPolicy }"
+	out, err = Canonicalize(shuffled)
+	require.NoError(t, err)
+	require.Equal(t, sorted, out)
+
+	again, changes, err := CanonicalizeWithReport(sorted)
+	require.NoError(t, err)
+	require.Equal(t, sorted, again)
+	require.Empty(t, changes)
+}
@@ -0,0 +1,311 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EquivalenceResult is the outcome of comparing two filters
+type EquivalenceResult int
+
+const (
+	// NotEquivalent means the filters match different log events
+	NotEquivalent EquivalenceResult = iota
+	// Equivalent means the filters match the same log events
+	Equivalent
+	// Unsupported means the filters use constructs this package can't compare,
+	// such as alternating logical operators, so equivalence is unknown
+	Unsupported
+)
+
+func (r EquivalenceResult) String() string {
+	switch r {
+	case Equivalent:
+		return "Equivalent"
+	case NotEquivalent:
+		return "NotEquivalent"
+	case Unsupported:
+		return "Unsupported"
+	}
+
+	return "EquivalenceResult(" + strconv.Itoa(int(r)) + ")"
+}
+
+// CompareOptions tweaks how expressions are compared. The zero value follows
+// CloudWatch semantics, which are case-sensitive.
+//
+// Equivalence compares the filters as written, so a `*` in a value is always
+// a literal character: `"a*"` is equivalent to `"a*"` but not to `"ab"`, even
+// though CloudWatch would match "ab" with the former. Wildcard aware matching
+// is never applied when checking equivalence.
+type CompareOptions struct {
+	// CaseInsensitiveValues compares the values of the clauses ignoring their
+	// case, so `$.eventName = consolelogin` matches `$.eventName = ConsoleLogin`.
+	// Selectors are always compared as they are.
+	CaseInsensitiveValues bool
+
+	// CaseInsensitiveUnquoted is like CaseInsensitiveValues but only for the
+	// unquoted values, so `$.eventSource = KMS.amazonaws.com` matches
+	// `$.eventSource = kms.amazonaws.com` while `"Root"` still doesn't match
+	// `"root"`.
+	CaseInsensitiveUnquoted bool
+
+	// QuoteInsensitiveValues compares values ignoring their quotes when they
+	// mean the same without them, so `$.eventName = "CreatePolicy"` matches
+	// `$.eventName = CreatePolicy`. Values like `"a b"` keep their quotes, and
+	// so do numbers: `"200"` is a string, never equivalent to the number 200.
+	QuoteInsensitiveValues bool
+
+	// TrimQuotedWhitespace ignores the spaces at the start and end of quoted
+	// values, so `"AcceptHandshake  "` matches `"AcceptHandshake"`. CloudWatch
+	// doesn't, as those spaces are part of the value, so it's only meant for
+	// lenient comparisons.
+	TrimQuotedWhitespace bool
+
+	// StrictOrder compares the clauses of groups by position, so reordering
+	// them, which doesn't change the events matched, makes filters different.
+	// It's meant to spot cosmetic changes, like in a diff.
+	StrictOrder bool
+
+	cache *equivalenceCache // memoizes the comparisons of groups, if set
+}
+
+// exact tells if clauses are only equivalent when their operands are identical
+func (opts CompareOptions) exact() bool {
+	return !opts.CaseInsensitiveValues && !opts.CaseInsensitiveUnquoted && !opts.QuoteInsensitiveValues &&
+		!opts.TrimQuotedWhitespace
+}
+
+// hash returns the Hash of e, already reduced, when values are compared as
+// they are written, as equivalent expressions then have the same hash, or 0 for
+// any expression otherwise. Equal hashes still need to be confirmed by
+// comparing expressions.
+func (opts CompareOptions) hash(e Expression) uint64 {
+	if !opts.exact() {
+		return 0
+	}
+
+	return reducedHash(e)
+}
+
+func (opts CompareOptions) valuesEqual(a, b string) bool {
+	return opts.valueKey(a) == opts.valueKey(b)
+}
+
+// valueKey normalizes the value v so equal values according to opts get the same key
+func (opts CompareOptions) valueKey(v string) string {
+	foldCase := opts.CaseInsensitiveValues || (opts.CaseInsensitiveUnquoted && !strings.HasPrefix(v, "\""))
+	if opts.TrimQuotedWhitespace && len(v) >= 2 && strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
+		v = "\"" + strings.TrimSpace(v[1:len(v)-1]) + "\""
+	}
+
+	if opts.QuoteInsensitiveValues {
+		v = unquoteWord(v)
+	}
+
+	if foldCase {
+		return strings.ToLower(v)
+	}
+
+	return v
+}
+
+// maxCachedComparisons bounds how many results an equivalenceCache holds
+const maxCachedComparisons = 4096
+
+// equivalenceCache memoizes the comparisons of groups during one comparison,
+// so sub groups shared by several clauses of a filter are compared once.
+// Groups are keyed by their canonical form, see canonicalForm, which groups
+// only get while reduced with a cache. A nil cache doesn't memoize anything.
+type equivalenceCache struct {
+	results map[equivalenceKey]bool
+}
+
+type equivalenceKey struct {
+	a, b string
+	opts CompareOptions
+}
+
+// remember returns the result of comparing the reduced groups a and b
+// according to opts, calling equivalent when it isn't known yet
+func (c *equivalenceCache) remember(a, b complexExpression, opts CompareOptions, equivalent func() bool) bool {
+	if c == nil || a.form == "" || b.form == "" {
+		return equivalent()
+	}
+
+	if a.form == b.form {
+		return true // the same clauses, only in another order
+	}
+
+	opts.cache = nil
+	key := equivalenceKey{a: a.form, b: b.form, opts: opts}
+	if result, ok := c.results[key]; ok {
+		return result
+	}
+
+	result := equivalent()
+	if c.results == nil {
+		c.results = make(map[equivalenceKey]bool)
+	}
+	if len(c.results) < maxCachedComparisons {
+		c.results[key] = result
+	}
+
+	return result
+}
+
+// canonicalForm renders e, already reduced, the same way whatever the order of
+// its clauses and of their operands, so expressions with the same form are
+// equivalent
+func canonicalForm(e Expression) string {
+	switch exp := e.(type) {
+	case simpleExpression:
+		key, _ := clauseKey(exp)
+		return key
+	case complexExpression:
+		if exp.form != "" {
+			return exp.form
+		}
+
+		forms := make([]string, 0, len(exp.expressions))
+		for _, sub := range exp.expressions {
+			forms = append(forms, canonicalForm(sub))
+		}
+		slices.Sort(forms)
+
+		// each form is prefixed by its length, so no two groups share a form
+		var b strings.Builder
+		b.WriteString(string(exp.operator))
+		for _, form := range forms {
+			b.WriteString(strconv.Itoa(len(form)) + ":" + form)
+		}
+		return b.String()
+	}
+
+	return ""
+}
+
+// Comparison is the detailed outcome of comparing two filters
+type Comparison struct {
+	Result EquivalenceResult
+
+	// NormalizationApplied tells if equivalent filters are only equal once
+	// normalized, for instance reordering clauses, swapping operands, expanding
+	// IN lists or ignoring the case or the quotes of values. Filters written
+	// the same way, apart from spacing and redundant parenthesis, are equal as
+	// written.
+	NormalizationApplied bool
+}
+
+// CompareExpressions compares the filters a and b. Filters that can't be
+// compared return Unsupported along with the reason, while malformed filters
+// return NotEquivalent and the parse error.
+func CompareExpressions(a, b string) (EquivalenceResult, error) {
+	comparison, err := CompareExpressionsDetailed(a, b, CompareOptions{})
+	return comparison.Result, err
+}
+
+// CompareExpressionsDetailed compares the filters a and b according to opts,
+// like CompareExpressions, and also tells if the filters are equal as written
+// or only after normalization.
+func CompareExpressionsDetailed(a, b string, opts CompareOptions) (Comparison, error) {
+	statementA, err := parse(a)
+	if err != nil {
+		return Comparison{Result: parseErrorResult(err)}, err
+	}
+
+	// the common "did this filter change?" check: identical filters are only
+	// parsed once, to still report them when they're malformed
+	if a == b {
+		return Comparison{Result: Equivalent}, nil
+	}
+
+	statementB, err := parse(b)
+	if err != nil {
+		return Comparison{Result: parseErrorResult(err)}, err
+	}
+
+	if !opts.exact() {
+		opts.cache = &equivalenceCache{} // exact comparisons only scan groups of the same hash
+	}
+	if !statementA.isEquivalentWith(statementB, opts) {
+		return Comparison{Result: NotEquivalent}, nil
+	}
+
+	return Comparison{
+		Result:               Equivalent,
+		NormalizationApplied: !statementA.structurallyEqual(statementB),
+	}, nil
+}
+
+// EquivalentWithOptions reports whether the filters a and b match the same log
+// events, comparing them according to opts.
+func EquivalentWithOptions(a, b string, opts CompareOptions) (bool, error) {
+	comparison, err := CompareExpressionsDetailed(a, b, opts)
+	return comparison.Result == Equivalent, err
+}
+
+func parseErrorResult(err error) EquivalenceResult {
+	if errors.Is(err, ErrAlternatingLogicalOperators) || errors.Is(err, ErrMaxDepthReached) ||
+		errors.Is(err, ErrNotJSONPattern) || errors.Is(err, ErrInputTooLarge) || errors.Is(err, ErrParseTimeout) {
+		return Unsupported
+	}
+
+	return NotEquivalent
+}
+
+// EquivalentFast reports whether the filters a and b match the same log events,
+// like CompareExpressions, but is optimized for big flat filters such as
+// `$.eventName = A || $.eventName = B || ...`. When both filters are a single
+// group of simple clauses, their sorted clause keys are compared in order
+// instead of searching each clause in the other group. Other filters fall back
+// to the general comparison.
+func EquivalentFast(a, b string) (bool, error) {
+	statementA, err := parse(a)
+	if err != nil {
+		return false, err
+	}
+
+	statementB, err := parse(b)
+	if err != nil {
+		return false, err
+	}
+
+	opA, keysA, okA := flatClauseKeys(statementA)
+	opB, keysB, okB := flatClauseKeys(statementB)
+	if okA && okB {
+		return opA == opB && slices.Equal(keysA, keysB), nil
+	}
+
+	return statementA.isEquivalent(statementB), nil
+}
+
+// flatClauseKeys returns the operator and the sorted clause keys of a group
+// made only of simple clauses, or false for any other expression
+func flatClauseKeys(e Expression) (logicalOperator, []string, bool) {
+	c, ok := e.(complexExpression)
+	if !ok || len(c.expressions) < 2 {
+		return "", nil, false
+	}
+
+	keys := make([]string, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		key, ok := clauseKey(exp)
+		if !ok {
+			return "", nil, false
+		}
+		keys = append(keys, key)
+	}
+
+	// duplicated clauses match the same events once, a group left with a
+	// single clause is compared as that clause
+	sort.Strings(keys)
+	if keys = slices.Compact(keys); len(keys) < 2 {
+		return "", nil, false
+	}
+
+	return c.operator, keys, true
+}
@@ -0,0 +1,620 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// organizationsFilter is the CIS AWS Organizations changes filter, and
+// organizationsFilterMixedQuotes the same filter quoting only some values
+const (
+	organizationsFilter            = "{ ($.eventSource = organizations.amazonaws.com) && (($.eventName = \"AcceptHandshake\") || ($.eventName = \"AttachPolicy\") || ($.eventName = \"CreateAccount\") || ($.eventName = \"CreateOrganizationalUnit\") || ($.eventName = \"CreatePolicy\") || ($.eventName = \"DeclineHandshake\") || ($.eventName = \"DeleteOrganization\") || ($.eventName = \"DeleteOrganizationalUnit\") || ($.eventName = \"DeletePolicy\") || ($.eventName = \"DetachPolicy\") || ($.eventName = \"DisablePolicyType\") || ($.eventName = \"EnablePolicyType\") || ($.eventName = \"InviteAccountToOrganization\") || ($.eventName = \"LeaveOrganization\") || ($.eventName = \"MoveAccount\") || ($.eventName = \"RemoveAccountFromOrganization\") || ($.eventName = \"UpdatePolicy\") || ($.eventName = \"UpdateOrganizationalUnit\")) }"
+	organizationsFilterMixedQuotes = "{ (($.eventName = AcceptHandshake) || ($.eventName = \"AttachPolicy\") || ($.eventName = CreateAccount) || ($.eventName = \"CreateOrganizationalUnit\") || ($.eventName = CreatePolicy) || ($.eventName = \"DeclineHandshake\") || ($.eventName = DeleteOrganization) || ($.eventName = \"DeleteOrganizationalUnit\") || ($.eventName = DeletePolicy) || ($.eventName = \"DetachPolicy\") || ($.eventName = DisablePolicyType) || ($.eventName = \"EnablePolicyType\") || ($.eventName = InviteAccountToOrganization) || ($.eventName = \"LeaveOrganization\") || ($.eventName = MoveAccount) || ($.eventName = \"RemoveAccountFromOrganization\") || ($.eventName = UpdatePolicy) || ($.eventName = \"UpdateOrganizationalUnit\")) && (\"organizations.amazonaws.com\" = $.eventSource) }"
+)
+
+func TestEquivalentWithOptions(t *testing.T) {
+	cases := map[string]struct {
+		expA               string
+		expB               string
+		opts               CompareOptions
+		shouldBeEquivalent bool
+	}{
+		"case-sensitive by default": {
+			expA:               "{ $.eventName = consolelogin }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			shouldBeEquivalent: false,
+		},
+		"quoted and unquoted numbers by default": {
+			expA:               "{ $.errorCode = 200 }",
+			expB:               "{ $.errorCode = \"200\" }",
+			shouldBeEquivalent: false,
+		},
+		"quoted and unquoted numbers ignoring quotes": {
+			expA:               "{ $.errorCode IN [200, 404] }",
+			expB:               "{ $.errorCode = \"200\" || $.errorCode = \"404\" }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true, CaseInsensitiveValues: true},
+			shouldBeEquivalent: false,
+		},
+		"quoted numbers ignoring quotes": {
+			expA:               "{ $.errorCode = \"-1.5\" && $.eventName = \"CreatePolicy\" }",
+			expB:               "{ $.errorCode = \"-1.5\" && $.eventName = CreatePolicy }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"whitespace in quoted values by default": {
+			expA:               "{ $.eventName = \"AcceptHandshake  \" }",
+			expB:               "{ $.eventName = \"AcceptHandshake\" }",
+			shouldBeEquivalent: false,
+		},
+		"trimmed whitespace in quoted values": {
+			expA:               "{ $.eventName = \"AcceptHandshake  \" || $.eventName = \" DeclineHandshake\" }",
+			expB:               "{ $.eventName = \"DeclineHandshake\" || $.eventName = \"AcceptHandshake\" }",
+			opts:               CompareOptions{TrimQuotedWhitespace: true},
+			shouldBeEquivalent: true,
+		},
+		"trimmed whitespace keeps inner spaces": {
+			expA:               "{ $.errorMessage = \" Failed  authentication \" }",
+			expB:               "{ $.errorMessage = \"Failed authentication\" }",
+			opts:               CompareOptions{TrimQuotedWhitespace: true},
+			shouldBeEquivalent: false,
+		},
+		"trimmed whitespace and ignored quotes": {
+			expA:               "{ $.eventName = \"AcceptHandshake \" }",
+			expB:               "{ $.eventName = AcceptHandshake }",
+			opts:               CompareOptions{TrimQuotedWhitespace: true, QuoteInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive values": {
+			expA:               "{ $.eventName = consolelogin }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive quoted values": {
+			expA:               "{ $.eventName = \"consolelogin\" }",
+			expB:               "{ $.eventName = \"ConsoleLogin\" }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive values with swapped operands": {
+			expA:               "{ consolelogin = $.eventName }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive values in complex expressions": {
+			expA:               "{ ($.eventName = consolelogin) && ($.additionalEventData.MFAUsed != \"YES\") }",
+			expB:               "{ ($.additionalEventData.MFAUsed != \"Yes\") && ($.eventName = ConsoleLogin) }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"wildcard stays literal on case-insensitive values": {
+			expA:               "{ $.errorCode = \"accessdenied*\" }",
+			expB:               "{ $.errorCode = \"AccessDeniedException\" }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: false,
+		},
+		"selectors stay case-sensitive": {
+			expA:               "{ $.eventname = ConsoleLogin }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: false,
+		},
+		"selectors stay case-sensitive with swapped operands": {
+			expA:               "{ ConsoleLogin = $.eventname }",
+			expB:               "{ $.eventName = consolelogin }",
+			opts:               CompareOptions{CaseInsensitiveValues: true},
+			shouldBeEquivalent: false,
+		},
+		"case-insensitive unquoted values": {
+			expA:               "{ $.eventSource = KMS.amazonaws.com }",
+			expB:               "{ $.eventSource = kms.amazonaws.com }",
+			opts:               CompareOptions{CaseInsensitiveUnquoted: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive unquoted values keep quoted values exact": {
+			expA:               "{ $.userIdentity.type = \"Root\" }",
+			expB:               "{ $.userIdentity.type = \"root\" }",
+			opts:               CompareOptions{CaseInsensitiveUnquoted: true},
+			shouldBeEquivalent: false,
+		},
+		"case-insensitive unquoted values against quoted value": {
+			expA:               "{ $.userIdentity.type = ROOT }",
+			expB:               "{ $.userIdentity.type = \"root\" }",
+			opts:               CompareOptions{CaseInsensitiveUnquoted: true},
+			shouldBeEquivalent: false,
+		},
+		"case-insensitive unquoted values in complex expressions": {
+			expA:               "{ ($.eventSource = KMS.amazonaws.com) && ($.userIdentity.type = \"Root\") && ($.eventName IN [DisableKey, SCHEDULEKEYDELETION]) }",
+			expB:               "{ ($.eventName = schedulekeydeletion || $.eventName = disablekey) && (\"Root\" = $.userIdentity.type) && (kms.amazonaws.com = $.eventSource) }",
+			opts:               CompareOptions{CaseInsensitiveUnquoted: true},
+			shouldBeEquivalent: true,
+		},
+		"case-insensitive unquoted values keep selectors exact": {
+			expA:               "{ $.EventSource = kms.amazonaws.com }",
+			expB:               "{ $.eventSource = kms.amazonaws.com }",
+			opts:               CompareOptions{CaseInsensitiveUnquoted: true},
+			shouldBeEquivalent: false,
+		},
+		"reordered clauses by default": {
+			expA:               "{ $.a = b && $.c = d }",
+			expB:               "{ $.c = d && $.a = b }",
+			shouldBeEquivalent: true,
+		},
+		"strict order reordered clauses": {
+			expA:               "{ $.a = b && $.c = d }",
+			expB:               "{ $.c = d && $.a = b }",
+			opts:               CompareOptions{StrictOrder: true},
+			shouldBeEquivalent: false,
+		},
+		"strict order reordered OR set": {
+			expA:               "{ $.eventName = A || $.eventName = B || $.eventName = C }",
+			expB:               "{ $.eventName = A || $.eventName = C || $.eventName = B }",
+			opts:               CompareOptions{StrictOrder: true},
+			shouldBeEquivalent: false,
+		},
+		"strict order reordered nested clauses": {
+			expA:               "{ $.a = b && ($.c = d || $.e = f) }",
+			expB:               "{ $.a = b && ($.e = f || $.c = d) }",
+			opts:               CompareOptions{StrictOrder: true},
+			shouldBeEquivalent: false,
+		},
+		"strict order same order": {
+			expA:               "{ ($.a = b) && (($.c = d) || ($.e = f)) }",
+			expB:               "{ $.a=b && ($.c=d || $.e=f) }",
+			opts:               CompareOptions{StrictOrder: true},
+			shouldBeEquivalent: true,
+		},
+		"strict order keeps swapped operands": {
+			expA:               "{ $.a = b && $.c < 1 }",
+			expB:               "{ b = $.a && 1 > $.c }",
+			opts:               CompareOptions{StrictOrder: true},
+			shouldBeEquivalent: true,
+		},
+		"strict order with case-insensitive values": {
+			expA:               "{ $.a = B && $.c = d }",
+			expB:               "{ $.a = b && $.c = D }",
+			opts:               CompareOptions{StrictOrder: true, CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"quoted values by default": {
+			expA:               "{ $.eventName = \"CreatePolicy\" }",
+			expB:               "{ $.eventName = CreatePolicy }",
+			shouldBeEquivalent: false,
+		},
+		"quote-insensitive values": {
+			expA:               "{ $.eventName = \"CreatePolicy\" }",
+			expB:               "{ CreatePolicy = $.eventName }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"quote-insensitive values keep needed quotes": {
+			expA:               "{ $.errorMessage = \"Failed authentication\" }",
+			expB:               "{ $.errorMessage = Failed }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true},
+			shouldBeEquivalent: false,
+		},
+		"quote-insensitive values in IN lists": {
+			expA:               "{ $.eventName IN [\"CreatePolicy\", DeletePolicy] }",
+			expB:               "{ $.eventName = \"DeletePolicy\" || $.eventName = CreatePolicy }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"quote-insensitive and case-insensitive values": {
+			expA:               "{ $.eventName = \"createpolicy\" }",
+			expB:               "{ $.eventName = CreatePolicy }",
+			opts:               CompareOptions{QuoteInsensitiveValues: true, CaseInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+		"organizations filter with mixed quotes by default": {
+			expA:               organizationsFilter,
+			expB:               organizationsFilterMixedQuotes,
+			shouldBeEquivalent: false,
+		},
+		"organizations filter with mixed quotes": {
+			expA:               organizationsFilter,
+			expB:               organizationsFilterMixedQuotes,
+			opts:               CompareOptions{QuoteInsensitiveValues: true},
+			shouldBeEquivalent: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := EquivalentWithOptions(tc.expA, tc.expB, tc.opts)
+			require.NoError(t, err)
+			require.Equal(t, tc.shouldBeEquivalent, equivalent)
+
+			equivalent, err = EquivalentWithOptions(tc.expB, tc.expA, tc.opts)
+			require.NoError(t, err)
+			require.Equal(t, tc.shouldBeEquivalent, equivalent)
+		})
+	}
+}
+
+func TestCompareExpressions(t *testing.T) {
+	cases := map[string]struct {
+		expA string
+		expB string
+		out  EquivalenceResult
+		err  error
+	}{
+		"equivalent": {
+			expA: "{ ($.eventName = ConsoleLogin) && ($.errorMessage = \"Failed authentication\") }",
+			expB: "{ ($.errorMessage = \"Failed authentication\") && ($.eventName = ConsoleLogin) }",
+			out:  Equivalent,
+		},
+		"not equivalent": {
+			expA: "{ ($.eventName = ConsoleLogin) && ($.errorMessage = \"Failed authentication\") }",
+			expB: "{ ($.eventName = ConsoleLogin) || ($.errorMessage = \"Failed authentication\") }",
+			out:  NotEquivalent,
+		},
+		"in operator and or of equals": {
+			expA: "{ $.eventName IN [CreateTrail, UpdateTrail, DeleteTrail] }",
+			expB: "{ ($.eventName = DeleteTrail) || ($.eventName = CreateTrail) || ($.eventName = UpdateTrail) }",
+			out:  Equivalent,
+		},
+		"in operator and or of equals with missing value": {
+			expA: "{ $.eventName IN [CreateTrail, UpdateTrail, DeleteTrail] }",
+			expB: "{ ($.eventName = DeleteTrail) || ($.eventName = CreateTrail) }",
+			out:  NotEquivalent,
+		},
+		"not in operator and and of not equals": {
+			expA: "{ $.eventName NOT IN [CreateTrail, UpdateTrail] }",
+			expB: "{ ($.eventName != UpdateTrail) && ($.eventName != CreateTrail) }",
+			out:  Equivalent,
+		},
+		"not in operator and or of not equals": {
+			expA: "{ $.eventName NOT IN [CreateTrail, UpdateTrail] }",
+			expB: "{ ($.eventName != UpdateTrail) || ($.eventName != CreateTrail) }",
+			out:  NotEquivalent,
+		},
+		"numeric operators with swapped operands": {
+			expA: "{ ($.bytes >= 1024) && ($.status < 500) }",
+			expB: "{ (500 > $.status) && (1024 <= $.bytes) }",
+			out:  Equivalent,
+		},
+		"unsupported alternating operators": {
+			expA: "{ ($.eventName = ConsoleLogin) && ($.errorMessage = \"Failed authentication\") }",
+			expB: "{ ($.eventName = ConsoleLogin) && ($.errorMessage = \"Failed authentication\") || ($.a = b) }",
+			out:  Unsupported,
+			err:  ErrAlternatingLogicalOperators,
+		},
+		"unsupported nested alternating operators": {
+			expA: "{ ($.eventName = ConsoleLogin) && (($.a = b) && ($.c = d) || ($.e = f)) }",
+			expB: "{ ($.eventName = ConsoleLogin) }",
+			out:  Unsupported,
+			err: SubExpressionError{
+				Expr: "($.a = b) && ($.c = d) || ($.e = f)",
+				Span: Span{StartByte: 35, EndByte: 70},
+				Err:  ErrAlternatingLogicalOperators,
+			},
+		},
+		"unsupported term pattern": {
+			expA: "ERROR -INFO",
+			expB: "{ $.level = ERROR }",
+			out:  Unsupported,
+			err:  ErrNotJSONPattern,
+		},
+		"unsupported depth": {
+			expA: "{ $.a = b }",
+			expB: "{((((((((((((a=b)&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))}",
+			out:  Unsupported,
+			err:  ErrMaxDepthReached,
+		},
+		"malformed expression": {
+			expA: "{ ($.eventName = ConsoleLogin }",
+			expB: "{ $.eventName = ConsoleLogin }",
+			out:  NotEquivalent,
+			err:  errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := CompareExpressions(tc.expA, tc.expB)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.expA, tc.expB)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out == Equivalent, equivalent)
+		})
+	}
+}
+
+func TestCompareExpressionsDetailed(t *testing.T) {
+	cases := map[string]struct {
+		expA string
+		expB string
+		opts CompareOptions
+		out  Comparison
+		err  error
+	}{
+		"identical": {
+			expA: "{ $.eventName = ConsoleLogin && $.errorMessage = \"Failed authentication\" }",
+			expB: "{ $.eventName = ConsoleLogin && $.errorMessage = \"Failed authentication\" }",
+			out:  Comparison{Result: Equivalent},
+		},
+		"different spacing and parenthesis": {
+			expA: "{ ($.eventName = ConsoleLogin) && ($.errorMessage = \"Failed authentication\") }",
+			expB: "{$.eventName=ConsoleLogin&&$.errorMessage=\"Failed authentication\"}",
+			out:  Comparison{Result: Equivalent},
+		},
+		"reordered clauses": {
+			expA: "{ $.eventName = ConsoleLogin && $.errorMessage = \"Failed authentication\" }",
+			expB: "{ $.errorMessage = \"Failed authentication\" && $.eventName = ConsoleLogin }",
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"swapped operands": {
+			expA: "{ $.bytes >= 1024 }",
+			expB: "{ 1024 <= $.bytes }",
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"IN list and OR group": {
+			expA: "{ $.eventName IN [CreateTrail, UpdateTrail] }",
+			expB: "{ $.eventName = CreateTrail || $.eventName = UpdateTrail }",
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"case-insensitive values": {
+			expA: "{ $.eventName = consolelogin }",
+			expB: "{ $.eventName = ConsoleLogin }",
+			opts: CompareOptions{CaseInsensitiveValues: true},
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"organizations filter with mixed quotes": {
+			expA: organizationsFilter,
+			expB: organizationsFilterMixedQuotes,
+			opts: CompareOptions{QuoteInsensitiveValues: true},
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"quote-insensitive values only differing by quotes": {
+			expA: "{ $.eventName = \"CreatePolicy\" }",
+			expB: "{ $.eventName = CreatePolicy }",
+			opts: CompareOptions{QuoteInsensitiveValues: true},
+			out:  Comparison{Result: Equivalent, NormalizationApplied: true},
+		},
+		"quote-insensitive values written the same": {
+			expA: "{ $.eventName = \"CreatePolicy\" }",
+			expB: "{ $.eventName = \"CreatePolicy\" }",
+			opts: CompareOptions{QuoteInsensitiveValues: true},
+			out:  Comparison{Result: Equivalent},
+		},
+		"not equivalent": {
+			expA: "{ $.eventName = ConsoleLogin }",
+			expB: "{ $.eventName = consolelogin }",
+			out:  Comparison{Result: NotEquivalent},
+		},
+		"unsupported": {
+			expA: "{ $.a = b && $.c = d || $.e = f }",
+			expB: "{ $.a = b }",
+			out:  Comparison{Result: Unsupported},
+			err:  ErrAlternatingLogicalOperators,
+		},
+		"identical unsupported": {
+			expA: "{ $.a = b && $.c = d || $.e = f }",
+			expB: "{ $.a = b && $.c = d || $.e = f }",
+			out:  Comparison{Result: Unsupported},
+			err:  ErrAlternatingLogicalOperators,
+		},
+		"identical malformed": {
+			expA: "{ ($.a = b }",
+			expB: "{ ($.a = b }",
+			out:  Comparison{Result: NotEquivalent},
+			err:  errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := CompareExpressionsDetailed(tc.expA, tc.expB, tc.opts)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+
+			out, err = CompareExpressionsDetailed(tc.expB, tc.expA, tc.opts)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestEquivalenceResult_String(t *testing.T) {
+	require.Equal(t, "Equivalent", Equivalent.String())
+	require.Equal(t, "NotEquivalent", NotEquivalent.String())
+	require.Equal(t, "Unsupported", Unsupported.String())
+	require.Equal(t, "EquivalenceResult(42)", EquivalenceResult(42).String())
+}
+
+func TestEquivalentFast(t *testing.T) {
+	cases := map[string]struct {
+		expA string
+		expB string
+		out  bool
+	}{
+		"flat OR group":                 {expA: "{ $.a = b || $.a = c || $.a = d }", expB: "{ $.a = d || c = $.a || $.a = b }", out: true},
+		"flat AND group":                {expA: "{ $.a = b && $.c != d && $.e NOT EXISTS }", expB: "{ $.e NOT EXISTS && d != $.c && $.a = b }", out: true},
+		"flat groups with mirrored ops": {expA: "{ $.a < 1 && $.b >= 2 }", expB: "{ 2 <= $.b && 1 > $.a }", out: true},
+		"flat groups with other values": {expA: "{ $.a = b || $.a = c }", expB: "{ $.a = b || $.a = d }", out: false},
+		"flat groups with other ops":    {expA: "{ $.a = b || $.c = d }", expB: "{ $.a = b && $.c = d }", out: false},
+		"flat groups with duplicates":   {expA: "{ $.a = b || $.a = b }", expB: "{ $.a = b || $.a = c }", out: false},
+		"flat groups of other sizes":    {expA: "{ $.a = b || $.c = d }", expB: "{ $.a = b || $.c = d || $.e = f }", out: false},
+		"nested groups fall back":       {expA: "{ $.a = b && ($.c = d || $.e = f) }", expB: "{ ($.e = f || $.c = d) && $.a = b }", out: true},
+		"IN lists fall back":            {expA: "{ $.a IN [b, c] || $.d = e }", expB: "{ $.a = c || $.d = e || $.a = b }", out: true},
+		"simple expressions fall back":  {expA: "{ $.a = b }", expB: "{ b = $.a }", out: true},
+		"simple against group fallback": {expA: "{ $.a = b }", expB: "{ $.a = b && $.a = b }", out: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := EquivalentFast(tc.expA, tc.expB)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, out)
+
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.expA, tc.expB)
+			require.NoError(t, err)
+			require.Equal(t, equivalent, out)
+		})
+	}
+
+	_, err := EquivalentFast("{ $.a = b }", "{ ($.a = b }")
+	require.Equal(t, errors.New("broken parenthesis"), err)
+}
+
+// largeOrFilters returns two equivalent OR filters of n clauses, close to the
+// 1KB pattern limit for n = 40
+func largeOrFilters(n int) (string, string) {
+	clausesA := make([]string, 0, n)
+	clausesB := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		clausesA = append(clausesA, "($.eventName = Event"+strconv.Itoa(i)+")")
+		clausesB = append(clausesB, "(Event"+strconv.Itoa(n-1-i)+" = $.eventName)")
+	}
+
+	return "{ " + strings.Join(clausesA, " || ") + " }", "{ " + strings.Join(clausesB, " || ") + " }"
+}
+
+func BenchmarkEquivalentFast(b *testing.B) {
+	a, other := largeOrFilters(40)
+	for i := 0; i < b.N; i++ {
+		equivalent, err := EquivalentFast(a, other)
+		require.NoError(b, err)
+		require.True(b, equivalent)
+	}
+}
+
+func BenchmarkCompareExpressions_identical(b *testing.B) {
+	a, _ := largeOrFilters(40)
+	other := strings.Clone(a)
+	for i := 0; i < b.N; i++ {
+		result, err := CompareExpressions(a, other)
+		require.NoError(b, err)
+		require.Equal(b, Equivalent, result)
+	}
+}
+
+func BenchmarkCompareExpressions_identicalButSpacing(b *testing.B) {
+	a, _ := largeOrFilters(40)
+	other := " " + a
+	for i := 0; i < b.N; i++ {
+		result, err := CompareExpressions(a, other)
+		require.NoError(b, err)
+		require.Equal(b, Equivalent, result)
+	}
+}
+
+func BenchmarkEquivalentFast_general(b *testing.B) {
+	a, other := largeOrFilters(40)
+	for i := 0; i < b.N; i++ {
+		equivalent, err := areCloudWatchExpressionsEquivalent(a, other)
+		require.NoError(b, err)
+		require.True(b, equivalent)
+	}
+}
+
+// sharedGroupFilters builds two equivalent filters of n groups that all hold
+// the same nested group of KMS clauses, written in a different order
+func sharedGroupFilters(n int) (string, string) {
+	events := []string{"DisableKey", "ScheduleKeyDeletion", "DeleteAlias", "CreateGrant", "RetireGrant", "RevokeGrant", "PutKeyPolicy", "ImportKeyMaterial"}
+	clauses, reorderedClauses := make([]string, 0, len(events)), make([]string, 0, len(events))
+	for _, event := range events {
+		clauses = append(clauses, "($.eventSource = kms.amazonaws.com && $.eventName = "+event+")")
+		reorderedClauses = append(reorderedClauses, "($.eventName = "+event+" && $.eventSource = kms.amazonaws.com)")
+	}
+	slices.Reverse(reorderedClauses)
+	shared, reordered := "("+strings.Join(clauses, " || ")+")", "("+strings.Join(reorderedClauses, " || ")+")"
+
+	groupsA, groupsB := make([]string, 0, n), make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		region := "($.awsRegion = region-" + strconv.Itoa(i) + " || $.recipientAccountId = " + strconv.Itoa(i) + ")"
+		groupsA = append(groupsA, "("+shared+" && "+region+")")
+		groupsB = append(groupsB, "("+region+" && "+reordered+")")
+	}
+	slices.Reverse(groupsB)
+
+	return "{ " + strings.Join(groupsA, " || ") + " }", "{ " + strings.Join(groupsB, " || ") + " }"
+}
+
+func TestCompareExpressions_sharedGroups(t *testing.T) {
+	a, b := sharedGroupFilters(10)
+	result, err := CompareExpressions(a, b)
+	require.NoError(t, err)
+	require.Equal(t, Equivalent, result)
+
+	result, err = CompareExpressions(a, strings.Replace(b, "region-3 ", "region-33 ", 1))
+	require.NoError(t, err)
+	require.Equal(t, NotEquivalent, result)
+
+	equivalent, err := EquivalentWithOptions(a, b, CompareOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	require.True(t, equivalent)
+
+	equivalent, err = EquivalentWithOptions(a, b, CompareOptions{StrictOrder: true})
+	require.NoError(t, err)
+	require.False(t, equivalent)
+}
+
+func BenchmarkCompareExpressions_sharedGroups(b *testing.B) {
+	a, other := sharedGroupFilters(20)
+	for i := 0; i < b.N; i++ {
+		result, err := CompareExpressions(a, other)
+		require.NoError(b, err)
+		require.Equal(b, Equivalent, result)
+	}
+}
+
+func TestEquivalenceCache(t *testing.T) {
+	a, b := sharedGroupFilters(10)
+	expA, err := parse(a)
+	require.NoError(t, err)
+	expB, err := parse(b)
+	require.NoError(t, err)
+	lenient := CompareOptions{CaseInsensitiveValues: true}
+
+	cache := &equivalenceCache{}
+	require.True(t, expA.isEquivalentWith(expB, CompareOptions{CaseInsensitiveValues: true, cache: cache}))
+	require.NotEmpty(t, cache.results)
+	for key := range cache.results {
+		require.Equal(t, lenient, key.opts)
+	}
+
+	// the results of other options, or of other groups, aren't reused
+	different, err := parse(strings.Replace(b, "region-3 ", "region-33 ", 1))
+	require.NoError(t, err)
+	require.False(t, expA.isEquivalentWith(different, CompareOptions{CaseInsensitiveValues: true, cache: cache}))
+	require.False(t, expA.isEquivalentWith(different, CompareOptions{cache: cache}))
+	upper, err := parse(strings.ReplaceAll(b, "kms.amazonaws.com", "KMS.amazonaws.com"))
+	require.NoError(t, err)
+	require.True(t, expA.isEquivalentWith(upper, CompareOptions{CaseInsensitiveValues: true, cache: cache}))
+	require.False(t, expA.isEquivalentWith(upper, CompareOptions{cache: cache}))
+
+	full := &equivalenceCache{results: make(map[equivalenceKey]bool)}
+	for i := 0; i < maxCachedComparisons; i++ {
+		full.results[equivalenceKey{a: strconv.Itoa(i)}] = false
+	}
+	require.True(t, expA.isEquivalentWith(expB, CompareOptions{CaseInsensitiveValues: true, cache: full}))
+	require.Len(t, full.results, maxCachedComparisons)
+}
+
+func BenchmarkEquivalenceCache(b *testing.B) {
+	a, other := sharedGroupFilters(20)
+	expA, err := parse(a)
+	require.NoError(b, err)
+	expB, err := parse(other)
+	require.NoError(b, err)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.True(b, expA.isEquivalentWith(expB, CompareOptions{CaseInsensitiveValues: true, cache: &equivalenceCache{}}))
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.True(b, expA.isEquivalentWith(expB, CompareOptions{CaseInsensitiveValues: true}))
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// DiffKind classifies a difference between two filters
+type DiffKind int
+
+const (
+	// OnlyInA is a clause of the first filter missing from the second one
+	OnlyInA DiffKind = iota
+	// OnlyInB is a clause of the second filter missing from the first one
+	OnlyInB
+	// OperatorDiffers is a clause present in both filters with the same
+	// operands but a different comparison operator, like `$.a = x` and `$.a != x`
+	OperatorDiffers
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case OnlyInA:
+		return "OnlyInA"
+	case OnlyInB:
+		return "OnlyInB"
+	case OperatorDiffers:
+		return "OperatorDiffers"
+	}
+
+	return "DiffKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Difference is a clause that sets two filters apart. A and B are the clause
+// as rendered in each filter, empty on the side it's missing from.
+type Difference struct {
+	Kind DiffKind
+	A    string
+	B    string
+}
+
+// Diff lists the top level clauses that differ between the filters a and b,
+// sorted by kind and then by clause. Clauses are paired by equivalence, so
+// equivalent filters have no differences.
+//
+// It's meant for filters joined by the same logical operator, e.g. which event
+// names a filter adds to a baseline. A simple filter is compared as a group of
+// one clause, while filters joined by different operators are reported as a
+// whole on each side.
+func Diff(a, b string) ([]Difference, error) {
+	expA, err := parse(a)
+	if err != nil {
+		return nil, err
+	}
+
+	expB, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return diff(expA, expB), nil
+}
+
+func diff(a, b Expression) []Difference {
+	opA, clausesA := topLevelClauses(a)
+	opB, clausesB := topLevelClauses(b)
+	if opA != "" && opB != "" && opA != opB {
+		clausesA, clausesB = []Expression{a}, []Expression{b}
+	}
+
+	// remove every clause of a that has an equivalent in b
+	restB := make([]Expression, len(clausesB))
+	copy(restB, clausesB)
+	restA := make([]Expression, 0, len(clausesA))
+	for _, exp := range clausesA {
+		found, pos := findEquivalentPos(exp, restB, CompareOptions{})
+		if !found {
+			restA = append(restA, exp)
+			continue
+		}
+
+		restB = append(restB[:pos], restB[pos+1:]...)
+	}
+
+	differences := make([]Difference, 0, len(restA)+len(restB))
+	for _, exp := range restA {
+		if pos := findOperatorChange(exp, restB); pos >= 0 {
+			differences = append(differences, Difference{Kind: OperatorDiffers, A: exp.String(), B: restB[pos].String()})
+			restB = append(restB[:pos], restB[pos+1:]...)
+			continue
+		}
+
+		differences = append(differences, Difference{Kind: OnlyInA, A: exp.String()})
+	}
+
+	for _, exp := range restB {
+		differences = append(differences, Difference{Kind: OnlyInB, B: exp.String()})
+	}
+
+	sort.SliceStable(differences, func(i, j int) bool {
+		if differences[i].Kind != differences[j].Kind {
+			return differences[i].Kind < differences[j].Kind
+		}
+		if differences[i].A != differences[j].A {
+			return differences[i].A < differences[j].A
+		}
+		return differences[i].B < differences[j].B
+	})
+
+	return differences
+}
+
+// topLevelClauses returns the logical operator and clauses of e, a simple
+// expression being a single clause without operator. The IN lists of OR
+// groups and the NOT IN lists of AND groups are expanded, so `$.x NOT IN [a, b]`
+// and `$.x != b && $.x != a` have the same clauses.
+func topLevelClauses(e Expression) (logicalOperator, []Expression) {
+	if s, ok := e.(simpleExpression); ok && s.operator.isList() {
+		e = s.expanded()
+	}
+
+	if c, ok := e.(complexExpression); ok {
+		c = c.withExpandedIn()
+		return c.operator, c.expressions
+	}
+
+	return "", []Expression{e}
+}
+
+// findOperatorChange returns the position of the clause in others with the
+// same operands as exp but another comparison operator, or -1 if there is none.
+// Operands are compared in canonical form, see normalizedOperands, so
+// `$["a"] = x` and `$.a != x` only differ by their operator.
+func findOperatorChange(exp Expression, others []Expression) int {
+	s, ok := exp.(simpleExpression)
+	if !ok || s.operator.isList() {
+		return -1
+	}
+
+	s = s.normalizedOperands()
+	for i, other := range others {
+		o, ok := other.(simpleExpression)
+		if !ok || o.operator.isList() || o.operator == s.operator {
+			continue
+		}
+
+		o = o.normalizedOperands()
+
+		if (s.left == o.left && s.right == o.right) || (s.left == o.right && s.right == o.left) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// MissingEventNames returns the values baseline matches but candidate doesn't,
+// sorted, answering which events a new version of a filter stopped monitoring.
+// Both filters must be OR groups of equal clauses over the same selector, like
+// `$.eventName = A || $.eventName = B`, where IN lists and single clauses fit
+// too. Values are compared as written, so `"A"` and `A` are different.
+func MissingEventNames(baseline, candidate string) ([]string, error) {
+	selectorA, valuesA, err := parseEqualsSet(baseline)
+	if err != nil {
+		return nil, err
+	}
+
+	selectorB, valuesB, err := parseEqualsSet(candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	if selectorA != selectorB {
+		return nil, errors.New("filters match different selectors: " + selectorA + " and " + selectorB)
+	}
+
+	missing := make([]string, 0)
+	for _, v := range slices.Compact(valuesA) {
+		if _, found := slices.BinarySearch(valuesB, v); !found {
+			missing = append(missing, v)
+		}
+	}
+
+	return missing, nil
+}
+
+// parseEqualsSet parses s as an OR group of equal clauses over one selector,
+// returning the selector and the sorted values
+func parseEqualsSet(s string) (string, []string, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	group, ok := exp.(complexExpression)
+	if !ok {
+		group = complexExpression{operator: loOr, expressions: []Expression{exp}}
+	}
+
+	selector, values, ok := group.withExpandedIn().equalsSet(CompareOptions{})
+	if !ok {
+		return "", nil, errors.New("expected an OR group of equal clauses over a single selector")
+	}
+
+	return selector, values, nil
+}
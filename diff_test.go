@@ -0,0 +1,196 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out []Difference
+		err error
+	}{
+		"equivalent filters": {
+			a:   "{ ($.eventName = CreateRoute) || ($.eventName = DeleteRoute) }",
+			b:   "{ $.eventName = DeleteRoute || $.eventName = CreateRoute }",
+			out: []Difference{},
+		},
+		"added event names": {
+			a: "{ ($.eventName = CreateRoute) || ($.eventName = DeleteRoute) }",
+			b: "{ ($.eventName = ReplaceRoute) || ($.eventName = DeleteRoute) || ($.eventName = CreateRoute) || ($.eventName = CreateRouteTable) }",
+			out: []Difference{
+				{Kind: OnlyInB, B: "$.eventName = CreateRouteTable"},
+				{Kind: OnlyInB, B: "$.eventName = ReplaceRoute"},
+			},
+		},
+		"added and removed clauses": {
+			a: "{ $.eventSource = kms.amazonaws.com && $.eventName = DisableKey && $.a = b }",
+			b: "{ $.eventName = DisableKey && $.eventSource = kms.amazonaws.com && ($.c = d || $.e = f) }",
+			out: []Difference{
+				{Kind: OnlyInA, A: "$.a = b"},
+				{Kind: OnlyInB, B: "$.c = d || $.e = f"},
+			},
+		},
+		"operator differs": {
+			a: "{ $.eventName = ConsoleLogin && $.errorMessage = \"Failed authentication\" }",
+			b: "{ $.eventName = ConsoleLogin && $.errorMessage != \"Failed authentication\" && $.z = y }",
+			out: []Difference{
+				{Kind: OnlyInB, B: "$.z = y"},
+				{Kind: OperatorDiffers, A: "$.errorMessage = \"Failed authentication\"", B: "$.errorMessage != \"Failed authentication\""},
+			},
+		},
+		"operator differs with bracket notation": {
+			a: "{ $[\"a\"] = x && $.b = y }",
+			b: "{ $.b = y && x != $.a }",
+			out: []Difference{
+				{Kind: OperatorDiffers, A: "$[\"a\"] = x", B: "x != $.a"},
+			},
+		},
+		"simple filter against a group": {
+			a: "{ $.a = b }",
+			b: "{ $.a = b && $.c = d }",
+			out: []Difference{
+				{Kind: OnlyInB, B: "$.c = d"},
+			},
+		},
+		"different logical operators": {
+			a: "{ $.a = b && $.c = d }",
+			b: "{ $.a = b || $.c = d }",
+			out: []Difference{
+				{Kind: OnlyInA, A: "$.a = b && $.c = d"},
+				{Kind: OnlyInB, B: "$.a = b || $.c = d"},
+			},
+		},
+		"duplicated clause": {
+			a: "{ $.a = b || $.a = b }",
+			b: "{ $.a = b }",
+			out: []Difference{
+				{Kind: OnlyInA, A: "$.a = b"},
+			},
+		},
+		"reordered != chain": {
+			a:   "{ $.eventType != AwsServiceEvent && $.eventType != AwsApiCall }",
+			b:   "{ $.eventType != AwsApiCall && $.eventType != AwsServiceEvent }",
+			out: []Difference{},
+		},
+		"!= chain against NOT IN": {
+			a:   "{ $.x != a && $.x != b && $.y = c }",
+			b:   "{ $.x NOT IN [b, a] && $.y = c }",
+			out: []Difference{},
+		},
+		"NOT IN with a changed value": {
+			a: "{ $.x != a && $.x != b }",
+			b: "{ $.x NOT IN [a, c] }",
+			out: []Difference{
+				{Kind: OnlyInA, A: "$.x != b"},
+				{Kind: OnlyInB, B: "$.x != c"},
+			},
+		},
+		"!= in an OR group isn't a NOT IN": {
+			a: "{ $.x != a || $.x != b }",
+			b: "{ $.x NOT IN [a, b] }",
+			out: []Difference{
+				{Kind: OnlyInA, A: "$.x != a || $.x != b"},
+				{Kind: OnlyInB, B: "$.x NOT IN [a, b]"},
+			},
+		},
+		"error on malformed filter": {
+			a:   "{ $.a = b }",
+			b:   "{ ($.a = b }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Diff(tc.a, tc.b)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestDiffKind_String(t *testing.T) {
+	require.Equal(t, "OnlyInA", OnlyInA.String())
+	require.Equal(t, "OnlyInB", OnlyInB.String())
+	require.Equal(t, "OperatorDiffers", OperatorDiffers.String())
+	require.Equal(t, "DiffKind(7)", DiffKind(7).String())
+}
+
+func TestMissingEventNames(t *testing.T) {
+	iamPolicyChanges := "{($.eventName=DeleteGroupPolicy)||($.eventName=DeleteRolePolicy)||($.eventName=DeleteUserPolicy)||($.eventName=PutGroupPolicy)||($.eventName=PutRolePolicy)||($.eventName=PutUserPolicy)||($.eventName=CreatePolicy)||($.eventName=DeletePolicy)||($.eventName=CreatePolicyVersion)||($.eventName=DeletePolicyVersion)||($.eventName=AttachRolePolicy)||($.eventName=DetachRolePolicy)||($.eventName=AttachUserPolicy)||($.eventName=DetachUserPolicy)||($.eventName=AttachGroupPolicy)||($.eventName=DetachGroupPolicy)}"
+
+	cases := map[string]struct {
+		baseline  string
+		candidate string
+		out       []string
+		err       error
+	}{
+		"same filter": {
+			baseline:  iamPolicyChanges,
+			candidate: iamPolicyChanges,
+			out:       []string{},
+		},
+		"IAM policy filter without the group policies": {
+			baseline:  iamPolicyChanges,
+			candidate: "{($.eventName=DeleteRolePolicy)||($.eventName=DeleteUserPolicy)||($.eventName=PutRolePolicy)||($.eventName=PutUserPolicy)||($.eventName=CreatePolicy)||($.eventName=DeletePolicy)||($.eventName=CreatePolicyVersion)||($.eventName=DeletePolicyVersion)||($.eventName=AttachRolePolicy)||($.eventName=DetachRolePolicy)||($.eventName=AttachUserPolicy)||($.eventName=DetachUserPolicy)}",
+			out:       []string{"AttachGroupPolicy", "DeleteGroupPolicy", "DetachGroupPolicy", "PutGroupPolicy"},
+		},
+		"added event names aren't missing": {
+			baseline:  "{ $.eventName = CreatePolicy || $.eventName = DeletePolicy }",
+			candidate: "{ $.eventName = DeletePolicy || $.eventName = TagPolicy || CreatePolicy = $.eventName }",
+			out:       []string{},
+		},
+		"IN list and single clause": {
+			baseline:  "{ $.eventName IN [CreatePolicy, DeletePolicy, CreatePolicy] }",
+			candidate: "{ $.eventName = DeletePolicy }",
+			out:       []string{"CreatePolicy"},
+		},
+		"quoted values are different": {
+			baseline:  "{ $.eventName = \"CreatePolicy\" }",
+			candidate: "{ $.eventName = CreatePolicy }",
+			out:       []string{"\"CreatePolicy\""},
+		},
+		"bracket notation selectors": {
+			baseline:  `{ $["eventName"] = CreatePolicy || $['eventName'] = DeletePolicy }`,
+			candidate: "{ $.eventName = DeletePolicy }",
+			out:       []string{"CreatePolicy"},
+		},
+		"error on different selectors": {
+			baseline:  "{ $.eventName = CreatePolicy }",
+			candidate: "{ $.eventSource = iam.amazonaws.com }",
+			err:       errors.New("filters match different selectors: $.eventName and $.eventSource"),
+		},
+		"error on AND group": {
+			baseline:  "{ $.eventName = CreatePolicy && $.eventName = DeletePolicy }",
+			candidate: iamPolicyChanges,
+			err:       errors.New("expected an OR group of equal clauses over a single selector"),
+		},
+		"error on other operators": {
+			baseline:  iamPolicyChanges,
+			candidate: "{ $.eventName = CreatePolicy || $.eventName != DeletePolicy }",
+			err:       errors.New("expected an OR group of equal clauses over a single selector"),
+		},
+		"error on mixed selectors": {
+			baseline:  "{ $.eventName = CreatePolicy || $.eventSource = iam.amazonaws.com }",
+			candidate: iamPolicyChanges,
+			err:       errors.New("expected an OR group of equal clauses over a single selector"),
+		},
+		"error on malformed filter": {
+			baseline:  iamPolicyChanges,
+			candidate: "{ ($.eventName = CreatePolicy }",
+			err:       errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := MissingEventNames(tc.baseline, tc.candidate)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
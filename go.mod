@@ -3,12 +3,10 @@ module github.com/romulets/test-cloudwatch-expressions-comparisson
 go 1.21.6
 
 require (
-	github.com/mgudov/logic-expression-parser v0.1.4
 	github.com/stretchr/testify v1.7.0
 )
 
 require (
-	github.com/araddon/dateparse v0.0.0-20210429162001-6b43995a97de // indirect
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
@@ -0,0 +1,103 @@
+package cloudwatch_lep
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GrammarDescription describes the filters Parse accepts without options. It's
+// built from the operators the parser knows, registered ones included, so it
+// stays in sync with the parser. Fields are tagged to be marshalled to JSON.
+type GrammarDescription struct {
+	// ComparisonOperators compare the operands of a clause, longest first as
+	// the parser matches them
+	ComparisonOperators []ComparisonOperator `json:"comparisonOperators"`
+	// ListOperators take a list of values, like `$.x IN [a, b]`
+	ListOperators []ComparisonOperator `json:"listOperators"`
+	// ValuelessOperators take no value, like `$.x NOT EXISTS`
+	ValuelessOperators []ComparisonOperator `json:"valuelessOperators"`
+	// OperatorAliases are other tokens accepted for an operator, like `<>`
+	OperatorAliases map[string]ComparisonOperator `json:"operatorAliases"`
+	// LogicalOperators join the clauses and groups of a filter
+	LogicalOperators []LogicalOperator `json:"logicalOperators"`
+	// MaxDepth is how many levels of nested parenthesis are accepted
+	MaxDepth int `json:"maxDepth"`
+	// MaxInputLength is how many runes a filter may have
+	MaxInputLength int `json:"maxInputLength"`
+	// Rules are the structural rules filters follow, in plain words
+	Rules []string `json:"rules"`
+}
+
+// Grammar describes the filters Parse accepts without options
+func Grammar() GrammarDescription {
+	g := GrammarDescription{
+		ComparisonOperators: slices.Clone(listComparisonOperator()),
+		OperatorAliases:     make(map[string]ComparisonOperator, len(operatorAliases)),
+		LogicalOperators:    listLogicalOperators(),
+		MaxDepth:            maxDepth,
+		MaxInputLength:      maxInputLength,
+		Rules: []string{
+			"the filter may be wrapped in a single pair of braces, like { $.eventName = ConsoleLogin }, and holds no other brace outside quotes",
+			"a clause compares a selector, like $.eventName, to a value with a comparison operator; the value may come first",
+			"list operators take values between brackets separated by commas, like [a, \"b c\"]",
+			"values with spaces or operator characters are quoted with double quotes, escaping inner quotes with a backslash",
+			"clauses and groups are joined by logical operators, a group mixing && and || needs parenthesis, like (a && b) || c",
+			"parenthesis group clauses and may be nested up to " + strconv.Itoa(maxDepth) + " levels",
+		},
+	}
+
+	for _, op := range g.ComparisonOperators {
+		switch {
+		case op.isList():
+			g.ListOperators = append(g.ListOperators, op)
+		case op == coNotExists:
+			g.ValuelessOperators = append(g.ValuelessOperators, op)
+		}
+	}
+
+	for alias, op := range operatorAliases {
+		g.OperatorAliases[alias] = op
+	}
+
+	return g
+}
+
+// String renders g as a plain text summary
+func (g GrammarDescription) String() string {
+	var b strings.Builder
+	b.WriteString("comparison operators: " + joinOperators(g.ComparisonOperators) + "\n")
+	b.WriteString("list operators: " + joinOperators(g.ListOperators) + "\n")
+	b.WriteString("valueless operators: " + joinOperators(g.ValuelessOperators) + "\n")
+
+	aliases := make([]string, 0, len(g.OperatorAliases))
+	for alias, op := range g.OperatorAliases {
+		aliases = append(aliases, strconv.Quote(alias)+" for "+strconv.Quote(string(op)))
+	}
+	sort.Strings(aliases)
+	b.WriteString("operator aliases: " + strings.Join(aliases, ", ") + "\n")
+
+	logical := make([]string, len(g.LogicalOperators))
+	for i, op := range g.LogicalOperators {
+		logical[i] = string(op)
+	}
+	b.WriteString("logical operators: " + strings.Join(logical, " ") + "\n")
+	b.WriteString("max depth: " + strconv.Itoa(g.MaxDepth) + "\n")
+	b.WriteString("max input length: " + strconv.Itoa(g.MaxInputLength) + " runes\n")
+
+	for _, rule := range g.Rules {
+		b.WriteString("- " + rule + "\n")
+	}
+
+	return b.String()
+}
+
+func joinOperators(operators []ComparisonOperator) string {
+	names := make([]string, len(operators))
+	for i, op := range operators {
+		names[i] = strconv.Quote(string(op))
+	}
+
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,55 @@
+package cloudwatch_lep
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGrammar(t *testing.T) {
+	g := Grammar()
+	require.ElementsMatch(t, []ComparisonOperator{
+		Equal, NotEqual, NotExists, In, NotIn, LessThan, LessThanOrEqual, GreaterThan, GreaterThanOrEqual, RegexMatch,
+	}, g.ComparisonOperators)
+	require.Equal(t, []ComparisonOperator{NotExists}, g.ValuelessOperators)
+	require.Equal(t, []ComparisonOperator{NotIn, In}, g.ListOperators)
+	require.Equal(t, map[string]ComparisonOperator{"<>": NotEqual}, g.OperatorAliases)
+	require.Equal(t, []LogicalOperator{And, Or}, g.LogicalOperators)
+	require.Equal(t, 5, g.MaxDepth)
+	require.Equal(t, 65536, g.MaxInputLength)
+	require.NotEmpty(t, g.Rules)
+
+	// longest operators first, as the parser matches them
+	for i := 1; i < len(g.ComparisonOperators); i++ {
+		require.GreaterOrEqual(t, len(g.ComparisonOperators[i-1]), len(g.ComparisonOperators[i]))
+	}
+
+	// changing the description doesn't change the parser
+	g.ComparisonOperators[0] = "?"
+	require.NotContains(t, listComparisonOperator(), ComparisonOperator("?"))
+}
+
+func TestGrammar_registeredOperator(t *testing.T) {
+	registerForTest(t, "CONTAINS")
+	require.Contains(t, Grammar().ComparisonOperators, ComparisonOperator("CONTAINS"))
+}
+
+func TestGrammar_json(t *testing.T) {
+	data, err := json.Marshal(Grammar())
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, []any{"&&", "||"}, decoded["logicalOperators"])
+	require.Equal(t, float64(5), decoded["maxDepth"])
+	require.Equal(t, map[string]any{"<>": "!="}, decoded["operatorAliases"])
+}
+
+func TestGrammarDescription_String(t *testing.T) {
+	out := Grammar().String()
+	require.Contains(t, out, "comparison operators: \"NOT EXISTS\" \"NOT IN\" ")
+	require.Contains(t, out, "operator aliases: \"<>\" for \"!=\"\n")
+	require.Contains(t, out, "logical operators: && ||\n")
+	require.Contains(t, out, "max depth: 5\n")
+	require.Contains(t, out, "- parenthesis group clauses and may be nested up to 5 levels\n")
+}
@@ -0,0 +1,51 @@
+package cloudwatch_lep
+
+import "hash/fnv"
+
+// Hash returns a hash of e that is the same for equivalent expressions, so
+// filters can be deduplicated through a map instead of comparing every pair.
+// The order of the clauses, their grouping and the order of the operands of
+// each clause don't change the hash, and neither do duplicated clauses.
+// Different hashes mean the expressions aren't equivalent, while equal hashes
+// still need to be confirmed with Equals.
+func Hash(e Expression) uint64 {
+	return reducedHash(reduced(e, CompareOptions{}))
+}
+
+// reducedHash is Hash for e already reduced, whose groups hold distinct
+// expressions, see reduced
+func reducedHash(e Expression) uint64 {
+	if s, ok := e.(simpleExpression); ok {
+		key, _ := clauseKey(s)
+		return hashString(key)
+	}
+
+	c := e.(complexExpression)
+	if c.hash != 0 {
+		return c.hash
+	}
+
+	// children are summed so their order doesn't matter
+	var sum uint64
+	for _, exp := range c.expressions {
+		sum += mix(reducedHash(exp))
+	}
+
+	return mix(sum ^ hashString(string(c.operator)))
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix scrambles the bits of h, so summing hashes doesn't cancel them out
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,67 @@
+package cloudwatch_lep
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	cases := map[string]struct {
+		a    string
+		b    string
+		same bool
+	}{
+		"same filter":                   {a: "{ $.a = b }", b: "{$.a=b}", same: true},
+		"swapped operands":              {a: "{ $.a = b }", b: "{ b = $.a }", same: true},
+		"mirrored operator":             {a: "{ $.a < 10 }", b: "{ 10 > $.a }", same: true},
+		"reordered clauses":             {a: "{ $.a = b && $.c != d && $.e NOT EXISTS }", b: "{ $.e NOT EXISTS && d != $.c && $.a = b }", same: true},
+		"reordered nested groups":       {a: "{ $.a = b && ($.c = d || $.e = f) }", b: "{ ($.e = f || $.c = d) && $.a = b }", same: true},
+		"redundant parenthesis":         {a: "{ (($.a = b)) && ($.c = d) }", b: "{ $.c = d && $.a = b }", same: true},
+		"regrouped OR chain":            {a: "{ ($.a = b || $.c = d) || $.e = f }", b: "{ $.a = b || ($.c = d || $.e = f) }", same: true},
+		"IN list and OR group":          {a: "{ $.a IN [b, c] }", b: "{ $.a = c || $.a = b }", same: true},
+		"IN list inside an OR group":    {a: "{ $.a IN [b, c] || $.d = e }", b: "{ $.a = b || $.d = e || $.a = c }", same: true},
+		"IN list inside an AND group":   {a: "{ $.a IN [b, c] && $.d = e }", b: "{ $.d = e && ($.a = c || $.a = b) }", same: true},
+		"IN list with a single value":   {a: "{ $.a IN [b, b] }", b: "{ $.a = b }", same: true},
+		"NOT IN list and AND group":     {a: "{ $.a NOT IN [b, c] && $.d = e }", b: "{ $.a != c && $.d = e && $.a != b }", same: true},
+		"NOT IN list and OR group":      {a: "{ $.a NOT IN [b, c] }", b: "{ $.a != c || $.a != b }", same: false},
+		"quoted number":                 {a: "{ $.a = 200 }", b: "{ $.a = \"200\" }", same: false},
+		"different values":              {a: "{ $.a = b }", b: "{ $.a = c }", same: false},
+		"different comparison operator": {a: "{ $.a = b }", b: "{ $.a != b }", same: false},
+		"different logical operator":    {a: "{ $.a = b && $.c = d }", b: "{ $.a = b || $.c = d }", same: false},
+		"different nesting":             {a: "{ $.a = b && ($.c = d || $.e = f) }", b: "{ ($.a = b && $.c = d) || $.e = f }", same: false},
+		"duplicated clause":             {a: "{ $.a = b || $.a = b }", b: "{ $.a = b }", same: true},
+		"missing clause":                {a: "{ $.a = b && $.c = d }", b: "{ $.a = b && $.c = d && $.e = f }", same: false},
+		"duplicated nested group":       {a: "{ $.a = 1 || (($.b = 1 || $.c = 1) && ($.c = 1 || $.b = 1)) }", b: "{ $.a = 1 || $.b = 1 || $.c = 1 }", same: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, err := parse(tc.a)
+			require.NoError(t, err)
+			b, err := parse(tc.b)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.same, a.isEquivalent(b))
+			require.Equal(t, tc.same, Hash(a) == Hash(b))
+		})
+	}
+}
+
+func TestHash_dedup(t *testing.T) {
+	filters := []string{
+		"{ ($.eventName = CreateRoute) || ($.eventName = DeleteRoute) }",
+		"{ $.eventName = ConsoleLogin }",
+		"{ $.eventName IN [DeleteRoute, CreateRoute] }",
+		"{ ConsoleLogin = $.eventName }",
+		"{ $.eventName = ReplaceRoute }",
+	}
+
+	unique := make(map[uint64][]Expression)
+	for _, f := range filters {
+		exp, err := parse(f)
+		require.NoError(t, err)
+		unique[Hash(exp)] = append(unique[Hash(exp)], exp)
+	}
+
+	require.Len(t, unique, 3)
+}
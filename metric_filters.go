@@ -0,0 +1,59 @@
+package cloudwatch_lep
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// FilterError is the failure to parse the pattern of a named metric filter
+type FilterError struct {
+	Name string
+	Err  error
+}
+
+func (e FilterError) Error() string {
+	return "metric filter " + strconv.Quote(e.Name) + ": " + e.Err.Error()
+}
+
+func (e FilterError) Unwrap() error {
+	return e.Err
+}
+
+type describeMetricFiltersOutput struct {
+	MetricFilters []struct {
+		FilterName    string `json:"filterName"`
+		FilterPattern string `json:"filterPattern"`
+	} `json:"metricFilters"`
+}
+
+// ParseMetricFilters parses the patterns of the output of
+// `aws logs describe-metric-filters`, indexing them by filter name. Filters
+// that fail, like space-delimited term patterns such as `ERROR -INFO`, are left
+// out and reported as FilterErrors joined in the returned error, in the order
+// they appear. Malformed JSON fails without any expression.
+func ParseMetricFilters(jsonBytes []byte) (map[string]Expression, error) {
+	var out describeMetricFiltersOutput
+	if err := json.Unmarshal(jsonBytes, &out); err != nil {
+		return nil, err
+	}
+
+	expressions := make(map[string]Expression, len(out.MetricFilters))
+	var errs []error
+	for _, filter := range out.MetricFilters {
+		if _, ok := expressions[filter.FilterName]; ok {
+			errs = append(errs, FilterError{Name: filter.FilterName, Err: errors.New("duplicated filter name")})
+			continue
+		}
+
+		exp, err := parse(filter.FilterPattern)
+		if err != nil {
+			errs = append(errs, FilterError{Name: filter.FilterName, Err: err})
+			continue
+		}
+
+		expressions[filter.FilterName] = exp
+	}
+
+	return expressions, errors.Join(errs...)
+}
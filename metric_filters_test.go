@@ -0,0 +1,59 @@
+package cloudwatch_lep
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestParseMetricFilters(t *testing.T) {
+	data, err := os.ReadFile("testdata/describe-metric-filters.json")
+	require.NoError(t, err)
+
+	expressions, err := ParseMetricFilters(data)
+	require.EqualError(t, err, "metric filter \"ApplicationErrors\": not a JSON filter pattern, space-delimited term patterns are not supported\n"+
+		"metric filter \"AlternatingOperators\": not supported comparison with alternating logical operators")
+	require.ErrorIs(t, err, ErrAlternatingLogicalOperators)
+	require.ErrorIs(t, err, ErrNotJSONPattern)
+
+	var filterErr FilterError
+	require.ErrorAs(t, err, &filterErr)
+	require.Equal(t, "ApplicationErrors", filterErr.Name)
+
+	require.Len(t, expressions, 3)
+	require.Equal(t, ce("&&",
+		se("$.eventSource", coEqual, "kms.amazonaws.com"),
+		ce("||",
+			se("$.eventName", coEqual, "DisableKey"),
+			se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+		),
+	), withoutSpans(expressions["KMSKeyDeletion"]))
+	require.Equal(t, "$.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\"", expressions["RootAccountUsage"].String())
+	require.Contains(t, expressions, "UnauthorizedAPICalls")
+}
+
+func TestParseMetricFilters_duplicatedName(t *testing.T) {
+	expressions, err := ParseMetricFilters([]byte(`{"metricFilters": [
+		{"filterName": "a", "filterPattern": "{ $.a = b }"},
+		{"filterName": "a", "filterPattern": "{ $.c = d }"}
+	]}`))
+	require.EqualError(t, err, "metric filter \"a\": duplicated filter name")
+	require.Len(t, expressions, 1)
+	require.Equal(t, se("$.a", coEqual, "b"), withoutSpans(expressions["a"]))
+}
+
+func TestParseMetricFilters_malformedJSON(t *testing.T) {
+	expressions, err := ParseMetricFilters([]byte(`{"metricFilters": [`))
+	require.Nil(t, expressions)
+
+	var syntaxErr *json.SyntaxError
+	require.True(t, errors.As(err, &syntaxErr))
+}
+
+func TestParseMetricFilters_empty(t *testing.T) {
+	expressions, err := ParseMetricFilters([]byte(`{"metricFilters": []}`))
+	require.NoError(t, err)
+	require.Empty(t, expressions)
+}
@@ -0,0 +1,317 @@
+package cloudwatch_lep
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ParseOptions tweaks how filters are parsed. The zero value only accepts the
+// filter itself, with up to 5 levels of nested parenthesis and 65536 runes.
+// Each field Foo is also set by the Option WithFoo.
+type ParseOptions struct {
+	// StripTrailingComment ignores a `# comment` after the closing brace, as in
+	// `{ $.eventName = ConsoleLogin } # login-failures`.
+	StripTrailingComment bool
+
+	// MaxDepth is how many levels of nested parenthesis, and of redundant
+	// parenthesis around a clause or a value, are accepted before failing with
+	// ErrMaxDepthReached. Zero means the default of 5.
+	MaxDepth int
+
+	// StrictSelectors rejects clauses whose left operand isn't a JSON selector
+	// like `$.eventName` or `$.resources[0].type`.
+	StrictSelectors bool
+
+	// RequireDollarSelectors rejects clauses whose left operand doesn't start
+	// with `$`, like `eventName = ConsoleLogin`, as CloudWatch does. Unlike
+	// StrictSelectors, the rest of the selector isn't checked.
+	RequireDollarSelectors bool
+
+	// NormalizeQuotes drops the quotes around values that don't need them, so
+	// `$.eventName = "ConsoleLogin"` parses the same as `$.eventName = ConsoleLogin`.
+	NormalizeQuotes bool
+
+	// LogicalOperators restricts the logical operators a filter may use, e.g.
+	// only "&&" to forbid OR filters. Empty means both "&&" and "||".
+	LogicalOperators []string
+
+	// ExplicitPrecedence reports groups mixing && and || without parenthesis,
+	// like `a && b || c`, with an AmbiguousPrecedenceError pointing at them,
+	// instead of ErrAlternatingLogicalOperators alone. Mixes made explicit
+	// with parenthesis, like `(a && b) || c`, are always accepted.
+	ExplicitPrecedence bool
+
+	// OnClause is called with each simple clause as soon as it's parsed, in
+	// source order. Clauses that fail to parse aren't reported, but the ones
+	// before them are, even if parsing fails later on.
+	OnClause func(span Span, clause Expression)
+
+	// MaxInputLength is how many runes a filter may have before failing with
+	// ErrInputTooLarge, so huge inputs are rejected before being scanned. Zero
+	// means the default of 65536.
+	MaxInputLength int
+
+	// Timeout bounds how long parsing may take before failing with
+	// ErrParseTimeout. Zero means no timeout.
+	Timeout time.Duration
+
+	deadline  time.Time      // when Timeout expires, set once parsing starts
+	operators *operatorTable // the operators known when parsing starts
+}
+
+func (opts ParseOptions) maxDepth() int {
+	if opts.MaxDepth > 0 {
+		return opts.MaxDepth
+	}
+
+	return maxDepth
+}
+
+func (opts ParseOptions) maxInputLength() int {
+	if opts.MaxInputLength > 0 {
+		return opts.MaxInputLength
+	}
+
+	return maxInputLength
+}
+
+// timedOut tells if parsing went past the deadline of the Timeout option
+func (opts ParseOptions) timedOut() bool {
+	return !opts.deadline.IsZero() && time.Now().After(opts.deadline)
+}
+
+func (opts ParseOptions) allowsLogicalOp(op logicalOperator) bool {
+	return len(opts.LogicalOperators) == 0 || slices.Contains(opts.LogicalOperators, string(op))
+}
+
+// clauseParsed reports the clause to the OnClause callback, if any
+func (opts ParseOptions) clauseParsed(clause Expression) Expression {
+	if opts.OnClause != nil {
+		opts.OnClause(clause.Span(), clause)
+	}
+
+	return clause
+}
+
+// Option configures Parse
+type Option func(*ParseOptions)
+
+// WithMaxDepth accepts up to n levels of nested parenthesis
+func WithMaxDepth(n int) Option {
+	return func(opts *ParseOptions) {
+		opts.MaxDepth = n
+	}
+}
+
+// WithStrictSelectors only accepts JSON selectors on the left of a clause
+func WithStrictSelectors() Option {
+	return func(opts *ParseOptions) {
+		opts.StrictSelectors = true
+	}
+}
+
+// WithRequireDollarSelectors only accepts selectors starting with `$` on the
+// left of a clause
+func WithRequireDollarSelectors() Option {
+	return func(opts *ParseOptions) {
+		opts.RequireDollarSelectors = true
+	}
+}
+
+// WithNormalizeQuotes drops the quotes around values that don't need them
+func WithNormalizeQuotes() Option {
+	return func(opts *ParseOptions) {
+		opts.NormalizeQuotes = true
+	}
+}
+
+// WithLogicalOperators only accepts filters joined by the given logical
+// operators, "&&" or "||"
+func WithLogicalOperators(operators ...string) Option {
+	return func(opts *ParseOptions) {
+		opts.LogicalOperators = operators
+	}
+}
+
+// WithExplicitPrecedence reports the groups mixing && and || without
+// parenthesis with an AmbiguousPrecedenceError
+func WithExplicitPrecedence() Option {
+	return func(opts *ParseOptions) {
+		opts.ExplicitPrecedence = true
+	}
+}
+
+// WithOnClause calls f with each simple clause as soon as it's parsed
+func WithOnClause(f func(span Span, clause Expression)) Option {
+	return func(opts *ParseOptions) {
+		opts.OnClause = f
+	}
+}
+
+// WithStripTrailingComment ignores a `# comment` after the closing brace
+func WithStripTrailingComment() Option {
+	return func(opts *ParseOptions) {
+		opts.StripTrailingComment = true
+	}
+}
+
+// WithMaxInputLength accepts filters of up to n runes
+func WithMaxInputLength(n int) Option {
+	return func(opts *ParseOptions) {
+		opts.MaxInputLength = n
+	}
+}
+
+// WithTimeout fails parsing with ErrParseTimeout once it takes longer than d
+func WithTimeout(d time.Duration) Option {
+	return func(opts *ParseOptions) {
+		opts.Timeout = d
+	}
+}
+
+func newParseOptions(options []Option) ParseOptions {
+	opts := ParseOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	return opts
+}
+
+// ParseWithOptions parses the CloudWatch filter s according to opts
+func ParseWithOptions(s string, opts ParseOptions) (Expression, error) {
+	return parseWith(s, opts)
+}
+
+// stripTrailingComment removes what follows the closing brace of s when it's a
+// comment. Braces inside quoted values don't close the expression.
+func stripTrailingComment(s string) string {
+	start, _ := trimSpan(s, 0, len(s))
+	if !strings.HasPrefix(s[start:], "{") {
+		return s
+	}
+
+	quotes := quoteState{}
+	for i := start; i < len(s); i++ {
+		if quotes.next(s[i]) || s[i] != '}' {
+			continue
+		}
+
+		if rest := strings.TrimSpace(s[i+1:]); strings.HasPrefix(rest, "#") {
+			return s[:i+1]
+		}
+
+		return s
+	}
+
+	return s
+}
+
+var selectorPattern = regexp.MustCompile(`^\$(\.[\pL\pN_\-]+|\[(\d+|\*)\])+$`)
+
+// isSelector tells if s is a JSON selector, like `$.eventName` or `$.a[0].b`
+func isSelector(s string) bool {
+	return selectorPattern.MatchString(s)
+}
+
+// keyPattern matches the keys of a selector that can be written after a dot
+var keyPattern = regexp.MustCompile(`^[\pL\pN_\-]+$`)
+
+// canonicalizeSelector rewrites the selector s in dot notation, so `$.a.b`,
+// `$["a"]["b"]` and `$.a['b']` are the same selector. Keys that can't follow
+// a dot keep their brackets, with double quotes. Operands that aren't
+// selectors are returned as they are.
+func canonicalizeSelector(s string) string {
+	if !strings.HasPrefix(s, "$") || !strings.Contains(s, "[") {
+		return s // not a selector, or already in dot notation
+	}
+
+	var b strings.Builder
+	b.WriteString("$")
+	for rest := s[1:]; rest != ""; {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return s
+			}
+
+			b.WriteString(rest[:end])
+			rest = rest[end:]
+		case strings.HasPrefix(rest, `["`) || strings.HasPrefix(rest, "['"):
+			end := strings.IndexByte(rest[2:], rest[1]) + 2
+			if end < 2 || !strings.HasPrefix(rest[end+1:], "]") {
+				return s
+			}
+
+			key := rest[2:end]
+			switch {
+			case keyPattern.MatchString(key):
+				b.WriteString("." + key)
+			case strings.ContainsAny(key, `"\`):
+				return s
+			default:
+				b.WriteString(`["` + key + `"]`)
+			}
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return s
+			}
+
+			b.WriteString(rest[:end+1])
+			rest = rest[end+1:]
+		default:
+			return s
+		}
+	}
+
+	return b.String()
+}
+
+var numberPattern = regexp.MustCompile(`^-?(\d+(\.\d+)?|\.\d+)([eE][+-]?\d+)?$`)
+
+// isNumber tells if s is a numeric value, like `200`, `-1.5`, `.5` or `1e3`
+func isNumber(s string) bool {
+	return numberPattern.MatchString(s)
+}
+
+// isLiteral tells if v is a number or one of the literals true, false and
+// null, which CloudWatch matches by their JSON type rather than as strings
+func isLiteral(v string) bool {
+	return isNumber(v) || v == "true" || v == "false" || v == "null"
+}
+
+// unquoteWord removes the quotes around v when what is inside is a single word
+// that means the same without them. Words like IN keep their quotes, otherwise
+// they'd be read as an operator, like words starting with $ would be read as a
+// selector, and so do literals, as CloudWatch matches `200` or `true` against
+// numbers and booleans but `"200"` or `"true"` against strings.
+func unquoteWord(v string) string {
+	if len(v) < 3 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+
+	word := v[1 : len(v)-1]
+	if isLiteral(word) || strings.HasPrefix(word, "$") {
+		return v
+	}
+
+	for _, r := range word {
+		if !isWordChar(r) {
+			return v
+		}
+	}
+
+	if pos, _, _ := findComparisonOp(word); pos >= 0 {
+		return v
+	}
+
+	return word
+}
@@ -0,0 +1,416 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWithOptions_stripTrailingComment(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		opts ParseOptions
+		out  Expression
+		err  error
+	}{
+		"comment": {
+			in:   "{ $.a = b }  # login-failures",
+			opts: ParseOptions{StripTrailingComment: true},
+			out:  se("$.a", coEqual, "b"),
+		},
+		"comment without spaces": {
+			in:   "{$.a=b}#login-failures",
+			opts: ParseOptions{StripTrailingComment: true},
+			out:  se("$.a", coEqual, "b"),
+		},
+		"comment with operators": {
+			in:   "{ ($.a = b) || ($.c != d) } # a = b || (c != d) && e NOT EXISTS",
+			opts: ParseOptions{StripTrailingComment: true},
+			out: ce("||",
+				se("$.a", coEqual, "b"),
+				se("$.c", coNotEqual, "d"),
+			),
+		},
+		"comment with braces and quotes": {
+			in:   "{ $.a = b } # { \"c\" } }",
+			opts: ParseOptions{StripTrailingComment: true},
+			out:  se("$.a", coEqual, "b"),
+		},
+		"brace inside quoted value": {
+			in:   "{ $.a = \"} # not a comment\" } # comment",
+			opts: ParseOptions{StripTrailingComment: true},
+			out:  se("$.a", coEqual, "\"} # not a comment\""),
+		},
+		"no comment": {
+			in:   "{ $.a = b }",
+			opts: ParseOptions{StripTrailingComment: true},
+			out:  se("$.a", coEqual, "b"),
+		},
+		"error on comment by default": {
+			in:  "{ $.a = b }  # login-failures",
+			err: errors.New("unexpected brace inside expression"),
+		},
+		"error on text that isn't a comment": {
+			in:   "{ $.a = b } login-failures",
+			opts: ParseOptions{StripTrailingComment: true},
+			err:  errors.New("unexpected brace inside expression"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := ParseWithOptions(tc.in, tc.opts)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, withoutSpans(out))
+		})
+	}
+}
+
+func TestParse_options(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		opts []Option
+		out  Expression
+		err  error
+	}{
+		"defaults": {
+			in:  "{ $.a = \"b\" } # comment",
+			err: errors.New("unexpected brace inside expression"),
+		},
+		"strip comments": {
+			in:   "{ $.a = \"b\" } # comment",
+			opts: []Option{WithStripTrailingComment()},
+			out:  se("$.a", coEqual, "\"b\""),
+		},
+		"normalize quotes": {
+			in:   "{ $.a = \"b-c\" && $.d != \"e f\" && $.g IN [\"h\", i, \"j k\"] }",
+			opts: []Option{WithNormalizeQuotes()},
+			out: ce("&&",
+				se("$.a", coEqual, "b-c"),
+				se("$.d", coNotEqual, "\"e f\""),
+				sin("$.g", "h", "i", "\"j k\""),
+			),
+		},
+		"strict selectors": {
+			in:   "{ $.resources[0].type = a && $.userIdentity.arn != b }",
+			opts: []Option{WithStrictSelectors()},
+			out: ce("&&",
+				se("$.resources[0].type", coEqual, "a"),
+				se("$.userIdentity.arn", coNotEqual, "b"),
+			),
+		},
+		"strict selectors reject spaces": {
+			in:   "{ $. eventName = a }",
+			opts: []Option{WithStrictSelectors()},
+			err:  errors.New("expected a selector like $.eventName"),
+		},
+		"strict selectors reject values": {
+			in:   "{ a = $.eventName }",
+			opts: []Option{WithStrictSelectors()},
+			err:  errors.New("expected a selector like $.eventName"),
+		},
+		"normalize quotes keeps numbers quoted": {
+			in:   "{ $.a = \"200\" && $.b IN [\"1e3\", \"x1\", \".5\", \"-.5\"] && $.c = 200 }",
+			opts: []Option{WithNormalizeQuotes()},
+			out: ce("&&",
+				se("$.a", coEqual, "\"200\""),
+				sin("$.b", "\"1e3\"", "x1", "\".5\"", "\"-.5\""),
+				se("$.c", coEqual, "200"),
+			),
+		},
+		"normalize quotes keeps literals quoted": {
+			in:   "{ $.a = \"true\" && $.b != \"false\" && $.c IN [\"null\", \"nil\"] && $.d = true }",
+			opts: []Option{WithNormalizeQuotes()},
+			out: ce("&&",
+				se("$.a", coEqual, "\"true\""),
+				se("$.b", coNotEqual, "\"false\""),
+				sin("$.c", "\"null\"", "nil"),
+				se("$.d", coEqual, "true"),
+			),
+		},
+		"bare selectors": {
+			in:  "{ eventName = ConsoleLogin && $.a = b }",
+			out: ce("&&", se("eventName", coEqual, "ConsoleLogin"), se("$.a", coEqual, "b")),
+		},
+		"dollar selectors": {
+			in:   "{ $. eventName = a && $.b NOT EXISTS }",
+			opts: []Option{WithRequireDollarSelectors()},
+			out:  ce("&&", se("$. eventName", coEqual, "a"), se("$.b", coNotExists, "")),
+		},
+		"dollar selectors reject bare selectors": {
+			in:   "{ $.a = b && eventName = ConsoleLogin }",
+			opts: []Option{WithRequireDollarSelectors()},
+			err:  errors.New("expected a selector starting with $"),
+		},
+		"dollar selectors reject values first": {
+			in:   "{ ConsoleLogin = $.eventName }",
+			opts: []Option{WithRequireDollarSelectors()},
+			err:  errors.New("expected a selector starting with $"),
+		},
+		"default max depth": {
+			in:  "{ a=b && (c=d || (e=f && (g=h || (i=j && (k=l || (m=n)))))) }",
+			err: ErrMaxDepthReached,
+		},
+		"higher max depth": {
+			in:   "{ a=b && (c=d || (e=f && (g=h || (i=j && (k=l || (m=n)))))) }",
+			opts: []Option{WithMaxDepth(6)},
+			out: ce("&&",
+				se("a", coEqual, "b"),
+				ce("||",
+					se("c", coEqual, "d"),
+					ce("&&",
+						se("e", coEqual, "f"),
+						ce("||",
+							se("g", coEqual, "h"),
+							ce("&&",
+								se("i", coEqual, "j"),
+								ce("||", se("k", coEqual, "l"), se("m", coEqual, "n")),
+							),
+						),
+					),
+				),
+			),
+		},
+		"AND only": {
+			in:   "{ $.a = b && ($.c = d && $.e = f) }",
+			opts: []Option{WithLogicalOperators("&&")},
+			out:  ce("&&", se("$.a", coEqual, "b"), ce("&&", se("$.c", coEqual, "d"), se("$.e", coEqual, "f"))),
+		},
+		"AND only rejects OR": {
+			in:   "{ $.a = b && ($.c = d || $.e = f) }",
+			opts: []Option{WithLogicalOperators("&&")},
+			err: SubExpressionError{
+				Expr: "$.c = d || $.e = f",
+				Span: Span{StartByte: 14, EndByte: 32},
+				Err:  errors.New("logical operator || is not allowed"),
+			},
+		},
+		"OR only rejects AND": {
+			in:   "{ $.a = b && $.c = d }",
+			opts: []Option{WithLogicalOperators("||")},
+			err:  errors.New("logical operator && is not allowed"),
+		},
+		"AND only ignores quoted OR": {
+			in:   "{ $.a = \"b || c\" }",
+			opts: []Option{WithLogicalOperators("&&")},
+			out:  se("$.a", coEqual, "\"b || c\""),
+		},
+		"both operators": {
+			in:   "{ $.a = b || ($.c = d && $.e = f) }",
+			opts: []Option{WithLogicalOperators("&&", "||")},
+			out:  ce("||", se("$.a", coEqual, "b"), ce("&&", se("$.c", coEqual, "d"), se("$.e", coEqual, "f"))),
+		},
+		"explicit precedence accepts parenthesized mixes": {
+			in:   "{ ($.a = b && $.c = d) || $.e = f }",
+			opts: []Option{WithExplicitPrecedence()},
+			out:  ce("||", ce("&&", se("$.a", coEqual, "b"), se("$.c", coEqual, "d")), se("$.e", coEqual, "f")),
+		},
+		"explicit precedence rejects bare mixes": {
+			in:   "{ $.a = b && $.c = d || $.e = f }",
+			opts: []Option{WithExplicitPrecedence()},
+			err:  AmbiguousPrecedenceError{Expr: "$.a = b && $.c = d || $.e = f", Span: Span{StartByte: 2, EndByte: 31}},
+		},
+		"explicit precedence rejects nested bare mixes": {
+			in:   "{ $.a = b || ($.c = d || $.e = f && $.g = h) }",
+			opts: []Option{WithExplicitPrecedence()},
+			err: SubExpressionError{
+				Expr: "$.c = d || $.e = f && $.g = h",
+				Span: Span{StartByte: 14, EndByte: 43},
+				Err:  AmbiguousPrecedenceError{Expr: "$.c = d || $.e = f && $.g = h", Span: Span{StartByte: 14, EndByte: 43}},
+			},
+		},
+		"bare mixes by default": {
+			in:  "{ $.a = b && $.c = d || $.e = f }",
+			err: ErrAlternatingLogicalOperators,
+		},
+		"depth 3 over a max depth of 2": {
+			in:   "{ $.a = b && ($.c = d || ($.e = f && ($.g = h || $.i = j))) }",
+			opts: []Option{WithMaxDepth(2)},
+			err:  ErrMaxDepthReached,
+		},
+		"depth 3 within a max depth of 3": {
+			in:   "{ $.a = b && ($.c = d || ($.e = f && ($.g = h || $.i = j))) }",
+			opts: []Option{WithMaxDepth(3)},
+			out: ce("&&",
+				se("$.a", coEqual, "b"),
+				ce("||",
+					se("$.c", coEqual, "d"),
+					ce("&&", se("$.e", coEqual, "f"), ce("||", se("$.g", coEqual, "h"), se("$.i", coEqual, "j"))),
+				),
+			),
+		},
+		"depth 4 over a max depth of 3": {
+			in:   "{ $.a = b && ($.c = d || ($.e = f && ($.g = h || ($.i = j && $.k = l)))) }",
+			opts: []Option{WithMaxDepth(3)},
+			err:  ErrMaxDepthReached,
+		},
+		"depth 4 within a max depth of 4": {
+			in:   "{ $.a = b && ($.c = d || ($.e = f && ($.g = h || ($.i = j && $.k = l)))) }",
+			opts: []Option{WithMaxDepth(4)},
+			out: ce("&&",
+				se("$.a", coEqual, "b"),
+				ce("||",
+					se("$.c", coEqual, "d"),
+					ce("&&",
+						se("$.e", coEqual, "f"),
+						ce("||", se("$.g", coEqual, "h"), ce("&&", se("$.i", coEqual, "j"), se("$.k", coEqual, "l"))),
+					),
+				),
+			),
+		},
+		"lower max depth": {
+			in:   "{ a=b && (c=d || (e=f)) }",
+			opts: []Option{WithMaxDepth(1)},
+			err:  ErrMaxDepthReached,
+		},
+		"within max input length": {
+			in:   "{ $.a = ü }",
+			opts: []Option{WithMaxInputLength(11)},
+			out:  se("$.a", coEqual, "ü"),
+		},
+		"lower max input length": {
+			in:   "{ $.a = bc }",
+			opts: []Option{WithMaxInputLength(11)},
+			err:  ErrInputTooLarge,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Parse(tc.in, tc.opts...)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, withoutSpans(out))
+		})
+	}
+}
+
+func TestNewParseOptions(t *testing.T) {
+	opts := newParseOptions([]Option{
+		WithStripTrailingComment(),
+		WithMaxDepth(7),
+		WithStrictSelectors(),
+		WithRequireDollarSelectors(),
+		WithNormalizeQuotes(),
+		WithLogicalOperators("&&"),
+		WithExplicitPrecedence(),
+		WithOnClause(func(span Span, clause Expression) {}),
+		WithMaxInputLength(10),
+		WithTimeout(time.Second),
+	})
+
+	require.NotNil(t, opts.OnClause)
+	opts.OnClause = nil
+	require.Equal(t, ParseOptions{
+		StripTrailingComment:   true,
+		MaxDepth:               7,
+		StrictSelectors:        true,
+		RequireDollarSelectors: true,
+		NormalizeQuotes:        true,
+		LogicalOperators:       []string{"&&"},
+		ExplicitPrecedence:     true,
+		MaxInputLength:         10,
+		Timeout:                time.Second,
+	}, opts)
+}
+
+func TestParse_clauseCallback(t *testing.T) {
+	in := "{ ($.a = b || $.c IN [d, e]) && (($.f != g) || $.h NOT EXISTS) && $.i > 1 }"
+
+	var clauses []string
+	_, err := Parse(in, WithOnClause(func(span Span, clause Expression) {
+		require.Equal(t, span, clause.Span())
+		clauses = append(clauses, in[span.StartByte:span.EndByte])
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"$.a = b", "$.c IN [d, e]", "$.f != g", "$.h NOT EXISTS", "$.i > 1"}, clauses)
+}
+
+func TestParse_clauseCallbackOnError(t *testing.T) {
+	var clauses []string
+	_, err := Parse("{ $.a = b && $.c && $.d = e }", WithOnClause(func(span Span, clause Expression) {
+		clauses = append(clauses, clause.String())
+	}))
+	require.Equal(t, errors.New("could not find a operator for this expression"), err)
+	require.Equal(t, []string{"$.a = b"}, clauses)
+
+	clauses = nil
+	_, err = Parse("{ ($.a = b }", WithOnClause(func(span Span, clause Expression) {
+		clauses = append(clauses, clause.String())
+	}))
+	require.Equal(t, errors.New("broken parenthesis"), err)
+	require.Empty(t, clauses)
+}
+
+func TestParse_largeInput(t *testing.T) {
+	huge := "{ $.a = " + strings.Repeat("b", 4<<20) + " }"
+	_, err := Parse(huge)
+	require.Equal(t, ErrInputTooLarge, err)
+
+	result, err := CompareExpressions(huge, "{ $.a = b }")
+	require.Equal(t, ErrInputTooLarge, err)
+	require.Equal(t, Unsupported, result)
+
+	large := "{ $.a = " + strings.Repeat("b", 100_000) + " }"
+	_, err = Parse(large)
+	require.Equal(t, ErrInputTooLarge, err)
+	exp, err := Parse(large, WithMaxInputLength(len(large)))
+	require.NoError(t, err)
+	require.Equal(t, "$.a", exp.(simpleExpression).left)
+}
+
+func TestParse_timeout(t *testing.T) {
+	// 30k redundant parenthesis around a value, within the input length limit
+	in := "{ $.a = " + strings.Repeat("(", 30_000) + "x" + strings.Repeat(")", 30_000) + " }"
+
+	start := time.Now()
+	_, err := Parse(in)
+	require.Equal(t, ErrMaxDepthReached, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	start = time.Now()
+	_, err = Parse(in, WithMaxDepth(len(in)), WithTimeout(10*time.Millisecond))
+	if err != nil {
+		require.Equal(t, ErrParseTimeout, err)
+	}
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	exp, err := Parse(in, WithMaxDepth(len(in)), WithTimeout(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, "$.a = x", exp.String())
+}
+
+func TestAmbiguousPrecedenceError(t *testing.T) {
+	_, err := Parse("{ $.a = b && $.c = d || $.e = f }", WithExplicitPrecedence())
+	require.ErrorIs(t, err, ErrAlternatingLogicalOperators)
+	require.EqualError(t, err, "ambiguous mix of && and || in \"$.a = b && $.c = d || $.e = f\", add parenthesis around the clauses to join first, like (a && b) || c")
+
+	result, err := CompareExpressions("{ $.a = b && $.c = d || $.e = f }", "{ $.a = b }")
+	require.Equal(t, ErrAlternatingLogicalOperators, err)
+	require.Equal(t, Unsupported, result)
+}
+
+func TestCanonicalizeSelector(t *testing.T) {
+	cases := map[string]string{
+		"$.foo.bar":              "$.foo.bar",
+		`$["foo"]["bar"]`:        "$.foo.bar",
+		`$.foo["bar"]`:           "$.foo.bar",
+		"$['foo'].bar":           "$.foo.bar",
+		`$.resources[0]["type"]`: "$.resources[0].type",
+		`$.a["x-ray"][*]`:        "$.a.x-ray[*]",
+		`$["key with spaces"]`:   `$["key with spaces"]`,
+		`$['a.b']`:               `$["a.b"]`,
+		`$['say "hi"']`:          `$['say "hi"']`,
+		`$.a["b`:                 `$.a["b`,
+		"$..a":                   "$..a",
+		"$5":                     "$5",
+		"ConsoleLogin":           "ConsoleLogin",
+	}
+
+	for in, out := range cases {
+		t.Run(in, func(t *testing.T) {
+			require.Equal(t, out, canonicalizeSelector(in))
+		})
+	}
+}
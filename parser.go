@@ -0,0 +1,58 @@
+package cloudwatch_lep
+
+import "sync/atomic"
+
+// Parser parses filters with the same options, counting what it parses so
+// services embedding it can observe the filters they process. Parse and
+// ParseWithOptions don't count anything. A Parser is safe for concurrent use.
+type Parser struct {
+	opts     ParseOptions
+	parses   atomic.Int64
+	errors   atomic.Int64
+	maxDepth atomic.Int64
+}
+
+// ParserStats are the counters of a Parser
+type ParserStats struct {
+	// Parses is how many filters were parsed, successfully or not
+	Parses int64
+	// Errors is how many of the parsed filters failed
+	Errors int64
+	// MaxDepth is how deeply the groups of the parsed filters are nested at
+	// most, as reported by Stats
+	MaxDepth int
+}
+
+// NewParser returns a Parser parsing filters with the given options
+func NewParser(opts ...Option) *Parser {
+	return &Parser{opts: newParseOptions(opts)}
+}
+
+// Parse parses the CloudWatch filter s like Parse, updating the counters of p
+func (p *Parser) Parse(s string) (Expression, error) {
+	p.parses.Add(1)
+
+	exp, err := parseWith(s, p.opts)
+	if err != nil {
+		p.errors.Add(1)
+		return nil, err
+	}
+
+	_, depth := Stats(exp)
+	for seen := p.maxDepth.Load(); int64(depth) > seen; seen = p.maxDepth.Load() {
+		if p.maxDepth.CompareAndSwap(seen, int64(depth)) {
+			break
+		}
+	}
+
+	return exp, nil
+}
+
+// Stats returns the counters of p since it was created
+func (p *Parser) Stats() ParserStats {
+	return ParserStats{
+		Parses:   p.parses.Load(),
+		Errors:   p.errors.Load(),
+		MaxDepth: int(p.maxDepth.Load()),
+	}
+}
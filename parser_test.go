@@ -0,0 +1,50 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	p := NewParser(WithMaxDepth(2))
+	require.Equal(t, ParserStats{}, p.Stats())
+
+	exp, err := p.Parse("{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }")
+	require.NoError(t, err)
+	require.Equal(t, "$.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion)", exp.String())
+
+	_, err = p.Parse("{ $.eventName = ConsoleLogin }")
+	require.NoError(t, err)
+
+	_, err = p.Parse("{ ($.a = b }")
+	require.Equal(t, errors.New("broken parenthesis"), err)
+
+	// the options of the parser apply
+	_, err = p.Parse("{ a=b && (c=d || (e=f && (g=h || i=j))) }")
+	require.Equal(t, ErrMaxDepthReached, err)
+
+	require.Equal(t, ParserStats{Parses: 4, Errors: 2, MaxDepth: 2}, p.Stats())
+}
+
+func TestParser_concurrentUse(t *testing.T) {
+	p := NewParser()
+	filters := []string{
+		"{ $.eventName = ConsoleLogin }",
+		"{ $.a = b && ($.c = d || ($.e = f && $.g = h)) }",
+		"{ $.a = b && $.c = d || $.e = f }",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			_, _ = p.Parse(s)
+		}(filters[i%len(filters)])
+	}
+	wg.Wait()
+
+	require.Equal(t, ParserStats{Parses: 30, Errors: 10, MaxDepth: 3}, p.Stats())
+}
@@ -0,0 +1,37 @@
+package cloudwatch_lep
+
+import "strings"
+
+// Pretty renders e like String, but with each clause on its own line, led by
+// the logical operator joining it to the previous one. The clauses of nested
+// groups are indented by width spaces per level, between parenthesis on their
+// own lines, so large filters are easier to review. The result parses back to
+// the same expression.
+func Pretty(e Expression, width int) string {
+	var b strings.Builder
+	writePretty(&b, e, 0, strings.Repeat(" ", max(width, 0)))
+	return b.String()
+}
+
+func writePretty(b *strings.Builder, e Expression, depth int, indent string) {
+	c, ok := e.(complexExpression)
+	if !ok {
+		b.WriteString(e.String())
+		return
+	}
+
+	for i, exp := range c.expressions {
+		if i > 0 {
+			b.WriteString("\n" + strings.Repeat(indent, depth) + string(c.operator) + " ")
+		}
+
+		if _, ok := exp.(complexExpression); !ok {
+			b.WriteString(exp.String())
+			continue
+		}
+
+		b.WriteString("(\n" + strings.Repeat(indent, depth+1))
+		writePretty(b, exp, depth+1, indent)
+		b.WriteString("\n" + strings.Repeat(indent, depth) + ")")
+	}
+}
@@ -0,0 +1,77 @@
+package cloudwatch_lep
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPretty(t *testing.T) {
+	cases := map[string]struct {
+		in    string
+		width int
+		out   string
+	}{
+		"simple expression": {
+			in:    "{ (($.eventName = ConsoleLogin)) }",
+			width: 2,
+			out:   "$.eventName = ConsoleLogin",
+		},
+		"flat group": {
+			in:    "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }",
+			width: 2,
+			out: "$.userIdentity.type = \"Root\"\n" +
+				"&& $.userIdentity.invokedBy NOT EXISTS\n" +
+				"&& $.eventType != \"AwsServiceEvent\"",
+		},
+		"KMS filter": {
+			in:    "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			width: 2,
+			out: "$.eventSource = kms.amazonaws.com\n" +
+				"&& (\n" +
+				"  $.eventName = DisableKey\n" +
+				"  || $.eventName = ScheduleKeyDeletion\n" +
+				")",
+		},
+		"KMS filter with wider indentation": {
+			in:    "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			width: 4,
+			out: "$.eventSource = kms.amazonaws.com\n" +
+				"&& (\n" +
+				"    $.eventName = DisableKey\n" +
+				"    || $.eventName = ScheduleKeyDeletion\n" +
+				")",
+		},
+		"deeply nested groups": {
+			in:    "{ (a=b || c=d) && (e=f || (g!=h && i IN [j, k])) }",
+			width: 2,
+			out: "(\n" +
+				"  a = b\n" +
+				"  || c = d\n" +
+				")\n" +
+				"&& (\n" +
+				"  e = f\n" +
+				"  || (\n" +
+				"    g != h\n" +
+				"    && i IN [j, k]\n" +
+				"  )\n" +
+				")",
+		},
+		"no indentation": {
+			in:    "{ a=b && (c=d || e=f) }",
+			width: 0,
+			out:   "a = b\n&& (\nc = d\n|| e = f\n)",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, Pretty(exp, tc.width))
+
+			again, err := parse(Pretty(exp, tc.width))
+			require.NoError(t, err)
+			require.True(t, exp.StructurallyEqual(again))
+		})
+	}
+}
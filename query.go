@@ -0,0 +1,187 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"slices"
+	"sort"
+)
+
+// ContainsClause reports whether the simple clause appears anywhere in the
+// filter expr, descending into nested groups. Clauses are matched by
+// equivalence, so `x = $.a` is found in `{ $.a = x && $.b = y }`.
+func ContainsClause(expr string, clause string) (bool, error) {
+	exp, err := parse(expr)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := parse(clause)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := c.(simpleExpression); !ok {
+		return false, errors.New("clause must be a single simple expression")
+	}
+
+	return containsClause(exp, c), nil
+}
+
+func containsClause(e Expression, clause Expression) bool {
+	if e.isEquivalent(clause) {
+		return true
+	}
+
+	if c, ok := e.(complexExpression); ok {
+		for _, exp := range c.expressions {
+			if containsClause(exp, clause) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// TopLevelOperator returns the logical operator joining the top level clauses
+// of the filter s, "&&" or "||", or an empty string for a simple expression.
+func TopLevelOperator(s string) (string, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if c, ok := exp.(complexExpression); ok {
+		return string(c.operator), nil
+	}
+
+	return "", nil
+}
+
+// EventSources returns the sorted and deduplicated values of the
+// `$.eventSource = X` clauses of the filter s, wherever they're nested, or an
+// empty slice if there's none. Values are returned as written, quotes included.
+func EventSources(s string) ([]string, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]string, 0)
+	for _, clause := range simpleClauses(exp) {
+		clause = clause.selectorFirst()
+		if canonicalizeSelector(clause.left) == "$.eventSource" && clause.operator == coEqual {
+			sources = append(sources, clause.right)
+		}
+	}
+
+	slices.Sort(sources)
+	return slices.Compact(sources), nil
+}
+
+// Clauses returns the top level expressions of e: the ones joined by its
+// logical operator, nested groups included as they are, or e itself when it's
+// a single clause.
+func Clauses(e Expression) []Expression {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return []Expression{e}
+	}
+
+	return slices.Clone(c.expressions)
+}
+
+// IsFlat tells if e is a single clause or a group of clauses without nested
+// groups, the filters fast paths like EquivalentFast and MergeOr apply to.
+func IsFlat(e Expression) bool {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return true
+	}
+
+	for _, exp := range c.expressions {
+		if _, ok := exp.(complexExpression); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Predicate is a comparison made by a filter: the selector Field, compared with
+// Operator to Value, which is empty for NOT EXISTS
+type Predicate struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+// Predicates returns the distinct comparisons made by the clauses of e,
+// sorted by field, operator and value. Clauses written value first, like
+// `ConsoleLogin = $.eventName`, are read selector first, with the selector in
+// dot notation, and lists give a predicate for each of their values: an =
+// comparison for IN and a != one for NOT IN.
+func Predicates(e Expression) []Predicate {
+	predicates := make([]Predicate, 0)
+	for _, clause := range simpleClauses(e) {
+		clause = clause.selectorFirst()
+		field := canonicalizeSelector(clause.left)
+		if !clause.operator.isList() {
+			predicates = append(predicates, Predicate{Field: field, Operator: string(clause.operator), Value: clause.right})
+			continue
+		}
+
+		operator := coEqual
+		if clause.operator == coNotIn {
+			operator = coNotEqual
+		}
+		for _, v := range clause.values {
+			predicates = append(predicates, Predicate{Field: field, Operator: string(operator), Value: v})
+		}
+	}
+
+	sort.Slice(predicates, func(i, j int) bool {
+		a, b := predicates[i], predicates[j]
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		if a.Operator != b.Operator {
+			return a.Operator < b.Operator
+		}
+		return a.Value < b.Value
+	})
+
+	return slices.Compact(predicates)
+}
+
+// Stats returns the number of simple clauses of e and how deeply its groups
+// are nested: 0 for a single clause, 1 for a group of clauses and one more for
+// each group nested in another one.
+func Stats(e Expression) (clauses int, depth int) {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return 1, 0
+	}
+
+	for _, exp := range c.expressions {
+		subClauses, subDepth := Stats(exp)
+		clauses += subClauses
+		depth = max(depth, subDepth)
+	}
+
+	return clauses, depth + 1
+}
+
+// ClauseOperator returns the comparison operator of e, when e is a simple
+// clause.
+func ClauseOperator(e Expression) (ComparisonOperator, bool) {
+	s, ok := e.(simpleExpression)
+	return s.operator, ok
+}
+
+// GroupOperator returns the logical operator joining the expressions of e,
+// when e is a group.
+func GroupOperator(e Expression) (LogicalOperator, bool) {
+	c, ok := e.(complexExpression)
+	return c.operator, ok
+}
@@ -0,0 +1,329 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestContainsClause(t *testing.T) {
+	cases := map[string]struct {
+		expr   string
+		clause string
+		out    bool
+		err    error
+	}{
+		"same simple expression": {
+			expr:   "{ $.eventName = ConsoleLogin }",
+			clause: "$.eventName = ConsoleLogin",
+			out:    true,
+		},
+		"top level clause": {
+			expr:   "{ ($.eventName = ConsoleLogin) && ($.additionalEventData.MFAUsed != \"Yes\") }",
+			clause: "{ $.eventName = ConsoleLogin }",
+			out:    true,
+		},
+		"swapped operands": {
+			expr:   "{ ($.eventName = ConsoleLogin) && ($.additionalEventData.MFAUsed != \"Yes\") }",
+			clause: "\"Yes\" != $.additionalEventData.MFAUsed",
+			out:    true,
+		},
+		"nested clause": {
+			expr:   "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			clause: "$.eventName = ScheduleKeyDeletion",
+			out:    true,
+		},
+		"different operator": {
+			expr:   "{ ($.eventName = ConsoleLogin) && ($.additionalEventData.MFAUsed != \"Yes\") }",
+			clause: "$.eventName != ConsoleLogin",
+			out:    false,
+		},
+		"missing clause": {
+			expr:   "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			clause: "$.eventName = ConsoleLogin",
+			out:    false,
+		},
+		"error on complex clause": {
+			expr:   "{ ($.eventName = ConsoleLogin) && ($.additionalEventData.MFAUsed != \"Yes\") }",
+			clause: "$.eventName = ConsoleLogin && $.additionalEventData.MFAUsed != \"Yes\"",
+			err:    errors.New("clause must be a single simple expression"),
+		},
+		"error on unparseable clause": {
+			expr:   "{ $.eventName = ConsoleLogin }",
+			clause: "$.eventName",
+			err:    errors.New("could not find a operator for this expression"),
+		},
+		"error on unparseable expression": {
+			expr:   "{ ($.eventName = ConsoleLogin }",
+			clause: "$.eventName = ConsoleLogin",
+			err:    errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := ContainsClause(tc.expr, tc.clause)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestEventSources(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out []string
+		err error
+	}{
+		"single source": {
+			in:  "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: []string{"kms.amazonaws.com"},
+		},
+		"nested, swapped and duplicated sources": {
+			in:  "{ ($.eventSource = s3.amazonaws.com && $.eventName = PutBucketAcl) || (config.amazonaws.com = $.eventSource && ($.eventSource = s3.amazonaws.com || $.eventName = StopConfigurationRecorder)) }",
+			out: []string{"config.amazonaws.com", "s3.amazonaws.com"},
+		},
+		"bracket notation selector": {
+			in:  `{ $["eventSource"] = kms.amazonaws.com || $.eventSource = s3.amazonaws.com }`,
+			out: []string{"kms.amazonaws.com", "s3.amazonaws.com"},
+		},
+		"other operators and selectors": {
+			in:  "{ $.eventSource != kms.amazonaws.com && $.eventSource IN [s3.amazonaws.com] && $.requestParameters.eventSource = iam.amazonaws.com }",
+			out: []string{},
+		},
+		"no source": {
+			in:  "{ $.eventName = ConsoleLogin }",
+			out: []string{},
+		},
+		"error on unparseable expression": {
+			in:  "{ ($.eventSource = kms.amazonaws.com }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := EventSources(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestTopLevelOperator(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out string
+		err error
+	}{
+		"simple expression": {
+			in:  "{ $.eventName = ConsoleLogin }",
+			out: "",
+		},
+		"simple expression with parenthesis": {
+			in:  "{ (($.eventName = ConsoleLogin)) }",
+			out: "",
+		},
+		"and expression": {
+			in:  "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: "&&",
+		},
+		"or expression": {
+			in:  "{ ($.eventName = CreateTrail) || ($.eventName = UpdateTrail) }",
+			out: "||",
+		},
+		"or expression with outer parenthesis": {
+			in:  "{ (($.eventName = CreateTrail) || ($.eventName = UpdateTrail)) }",
+			out: "||",
+		},
+		"error on unparseable expression": {
+			in:  "{ $.a = b && $.c = d || $.e = f }",
+			err: ErrAlternatingLogicalOperators,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := TopLevelOperator(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestClauses(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out []string
+	}{
+		"simple expression": {
+			in:  "{ (($.eventName = ConsoleLogin)) }",
+			out: []string{"$.eventName = ConsoleLogin"},
+		},
+		"or expression": {
+			in:  "{ ($.eventName = CreateTrail) || ($.eventName = UpdateTrail) || ($.eventName IN [DeleteTrail, StopLogging]) }",
+			out: []string{"$.eventName = CreateTrail", "$.eventName = UpdateTrail", "$.eventName IN [DeleteTrail, StopLogging]"},
+		},
+		"and expression with a nested group": {
+			in:  "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: []string{"$.eventSource = kms.amazonaws.com", "$.eventName = DisableKey || $.eventName = ScheduleKeyDeletion"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+
+			var out []string
+			for _, clause := range Clauses(exp) {
+				out = append(out, clause.String())
+			}
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestIsFlat(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+	}{
+		"simple expression":     {in: "{ (($.eventName = ConsoleLogin)) }", out: true},
+		"IN clause":             {in: "{ $.eventName IN [a, b, c] }", out: true},
+		"flat group":            {in: "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }", out: true},
+		"redundant parenthesis": {in: "{ ($.eventName = CreateTrail) || (($.eventName = UpdateTrail)) }", out: true},
+		"nested KMS filter":     {in: "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }", out: false},
+		"nested same operator":  {in: "{ $.a = b || ($.c = d || $.e = f) }", out: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, IsFlat(exp))
+		})
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out []Predicate
+	}{
+		"KMS filter": {
+			in: "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: []Predicate{
+				{Field: "$.eventName", Operator: "=", Value: "DisableKey"},
+				{Field: "$.eventName", Operator: "=", Value: "ScheduleKeyDeletion"},
+				{Field: "$.eventSource", Operator: "=", Value: "kms.amazonaws.com"},
+			},
+		},
+		"swapped operands and duplicates": {
+			in: "{ (ScheduleKeyDeletion = $.eventName || $.eventName = ScheduleKeyDeletion) && 10 < $.bytes }",
+			out: []Predicate{
+				{Field: "$.bytes", Operator: ">", Value: "10"},
+				{Field: "$.eventName", Operator: "=", Value: "ScheduleKeyDeletion"},
+			},
+		},
+		"lists and NOT EXISTS": {
+			in: "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType NOT IN [\"AwsServiceEvent\", AwsApiCall] }",
+			out: []Predicate{
+				{Field: "$.eventType", Operator: "!=", Value: "\"AwsServiceEvent\""},
+				{Field: "$.eventType", Operator: "!=", Value: "AwsApiCall"},
+				{Field: "$.userIdentity.invokedBy", Operator: "NOT EXISTS", Value: ""},
+				{Field: "$.userIdentity.type", Operator: "=", Value: "\"Root\""},
+			},
+		},
+		"IN lists and bracket notation": {
+			in: `{ $.eventName IN [DisableKey, ScheduleKeyDeletion] || $["eventName"] = DisableKey || $['eventSource'] != kms.amazonaws.com }`,
+			out: []Predicate{
+				{Field: "$.eventName", Operator: "=", Value: "DisableKey"},
+				{Field: "$.eventName", Operator: "=", Value: "ScheduleKeyDeletion"},
+				{Field: "$.eventSource", Operator: "!=", Value: "kms.amazonaws.com"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, Predicates(exp))
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	cases := map[string]struct {
+		in      string
+		clauses int
+		depth   int
+	}{
+		"simple expression": {
+			in:      "{ (($.eventName = ConsoleLogin)) }",
+			clauses: 1,
+			depth:   0,
+		},
+		"IN clause": {
+			in:      "{ $.eventName IN [a, b, c] }",
+			clauses: 1,
+			depth:   0,
+		},
+		"flat group": {
+			in:      "{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }",
+			clauses: 3,
+			depth:   1,
+		},
+		"nested KMS filter": {
+			in:      "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			clauses: 3,
+			depth:   2,
+		},
+		"4 layers deep expression": {
+			in:      "{((a=b) && ((c=d) || ((e=f) && (g!=h || (i=j)))))}",
+			clauses: 5,
+			depth:   4,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+
+			clauses, depth := Stats(exp)
+			require.Equal(t, tc.clauses, clauses)
+			require.Equal(t, tc.depth, depth)
+		})
+	}
+}
+
+func TestClauseAndGroupOperator(t *testing.T) {
+	exp, err := parse("{ ($.eventSource = kms.amazonaws.com) && ($.eventName IN [DisableKey, ScheduleKeyDeletion] || $.bytes >= 10) }")
+	require.NoError(t, err)
+
+	op, ok := GroupOperator(exp)
+	require.True(t, ok)
+	require.Equal(t, And, op)
+	_, ok = ClauseOperator(exp)
+	require.False(t, ok)
+
+	group := exp.(complexExpression).expressions
+	clauseOp, ok := ClauseOperator(group[0])
+	require.True(t, ok)
+	require.Equal(t, Equal, clauseOp)
+
+	op, ok = GroupOperator(group[1])
+	require.True(t, ok)
+	require.Equal(t, Or, op)
+
+	var ops []ComparisonOperator
+	for _, e := range group[1].(complexExpression).expressions {
+		clauseOp, ok := ClauseOperator(e)
+		require.True(t, ok)
+		require.Equal(t, string(clauseOp), e.Operator())
+		ops = append(ops, clauseOp)
+	}
+	require.Equal(t, []ComparisonOperator{In, GreaterThanOrEqual}, ops)
+}
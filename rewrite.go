@@ -0,0 +1,47 @@
+package cloudwatch_lep
+
+// Rename returns a copy of e where the selector from is replaced by to in every
+// clause, wherever it's nested, like when a log schema renames a field. Clauses
+// written value first, like `ConsoleLogin = $.eventName`, are renamed too, and
+// selectors match whatever their notation, so `$["eventName"]` is renamed as
+// `$.eventName`. The renamed clauses and the groups holding them no longer map
+// to the source, their Span is empty. e isn't modified.
+func Rename(e Expression, from, to string) Expression {
+	renamed, _ := rename(e, canonicalizeSelector(from), to)
+	return renamed
+}
+
+// rename implements Rename for the selector from in dot notation, telling if
+// any clause of e was renamed
+func rename(e Expression, from, to string) (Expression, bool) {
+	switch exp := e.(type) {
+	case simpleExpression:
+		switch {
+		case canonicalizeSelector(exp.left) == from:
+			exp.left = to
+		case canonicalizeSelector(exp.right) == from && canonicalizeSelector(exp.selectorFirst().left) == from:
+			exp.right = to
+		default:
+			return exp, false
+		}
+
+		exp.span = Span{}
+		return exp, true
+	case complexExpression:
+		changed := false
+		expressions := make([]Expression, 0, len(exp.expressions))
+		for _, sub := range exp.expressions {
+			sub, subChanged := rename(sub, from, to)
+			expressions = append(expressions, sub)
+			changed = changed || subChanged
+		}
+		exp.expressions = expressions
+		if changed {
+			exp.span, exp.hash = Span{}, 0
+		}
+
+		return exp, changed
+	}
+
+	return e, false
+}
@@ -0,0 +1,86 @@
+package cloudwatch_lep
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		from string
+		to   string
+		out  string
+	}{
+		"simple expression": {
+			in:   "{ $.eventName = ConsoleLogin }",
+			from: "$.eventName",
+			to:   "$.detail.eventName",
+			out:  "$.detail.eventName = ConsoleLogin",
+		},
+		"nested filter": {
+			in:   "{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			from: "$.eventName",
+			to:   "$.detail.eventName",
+			out:  "$.eventSource = kms.amazonaws.com && ($.detail.eventName = DisableKey || $.detail.eventName = ScheduleKeyDeletion)",
+		},
+		"lists and swapped operands": {
+			in:   "{ $.eventName IN [a, b] || (c = $.eventName && $.eventName NOT EXISTS) }",
+			from: "$.eventName",
+			to:   "$.detail.eventName",
+			out:  "$.detail.eventName IN [a, b] || (c = $.detail.eventName && $.detail.eventName NOT EXISTS)",
+		},
+		"values and prefixes are kept": {
+			in:   "{ $.eventName.type = a && $.a = $.eventName && $.b = eventName }",
+			from: "$.eventName",
+			to:   "$.detail.eventName",
+			out:  "$.eventName.type = a && $.a = $.eventName && $.b = eventName",
+		},
+		"bracket notation selectors": {
+			in:   `{ $["eventName"] = a || b = $['eventName'] || $.eventSource = c }`,
+			from: "$.eventName",
+			to:   "$.detail.eventName",
+			out:  "$.detail.eventName = a || b = $.detail.eventName || $.eventSource = c",
+		},
+		"bracket notation from": {
+			in:   "{ $.eventName = a }",
+			from: `$["eventName"]`,
+			to:   "$.detail.eventName",
+			out:  "$.detail.eventName = a",
+		},
+		"bare selectors": {
+			in:   "{ eventName = a || b = c }",
+			from: "eventName",
+			to:   "$.eventName",
+			out:  "$.eventName = a || b = c",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			before := exp.String()
+
+			renamed := Rename(exp, tc.from, tc.to)
+			require.Equal(t, tc.out, renamed.String())
+			require.Equal(t, before, exp.String())
+		})
+	}
+}
+
+func TestRename_spans(t *testing.T) {
+	exp, err := parse("{ $.eventSource = a && ($.eventName = b || $.c = d) }")
+	require.NoError(t, err)
+
+	renamed := Rename(exp, "$.eventName", "$.detail.eventName")
+	require.Equal(t, Span{}, renamed.Span())
+
+	clauses := Clauses(renamed)
+	require.Equal(t, exp.(complexExpression).expressions[0].Span(), clauses[0].Span())
+	require.Equal(t, Span{}, clauses[1].Span())
+
+	nested := Clauses(clauses[1])
+	require.Equal(t, Span{}, nested[0].Span())
+	require.Equal(t, Clauses(exp.(complexExpression).expressions[1])[1].Span(), nested[1].Span())
+}
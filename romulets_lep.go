@@ -1,75 +1,582 @@
+// Package cloudwatch_lep parses CloudWatch Logs JSON filter patterns and tells
+// if two of them match the same log events.
+//
+// Every function of the package is safe for concurrent use, and so are parsed
+// Expressions, which are never modified once returned. RegisterComparisonOperator
+// may run concurrently with parsing too, each parse using the operators known
+// when it starts.
 package cloudwatch_lep
 
 import (
 	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const maxDepth = 5
 
-type logicalOperator string
-type comparisonOperator string
+// maxInputLength is how many runes are scanned by default before giving up on
+// a filter, far above the 1024 bytes CloudWatch accepts
+const maxInputLength = 64 * 1024
+
+// Errors returned for expressions that are valid filters but can't be compared
+var (
+	ErrMaxDepthReached             = errors.New("max depth reached, can't parse this expression")
+	ErrAlternatingLogicalOperators = errors.New("not supported comparison with alternating logical operators")
+	ErrNotJSONPattern              = errors.New("not a JSON filter pattern, space-delimited term patterns are not supported")
+	ErrInputTooLarge               = errors.New("input too large, can't parse this expression")
+	ErrParseTimeout                = errors.New("parse timeout, can't parse this expression")
+)
+
+// ErrEmptyExpression is returned when parsing a blank filter or group, like
+// "", "{ }" or "{ () }"
+var ErrEmptyExpression = errors.New("empty expression")
+
+// ErrEmptyLeftOperand is returned for a clause starting with its operator, like
+// `= b` or `NOT EXISTS`, which has nothing to compare
+var ErrEmptyLeftOperand = errors.New("empty left operand")
+
+// ErrDanglingOperator is the cause of a DanglingOperatorError
+var ErrDanglingOperator = errors.New("dangling logical operator")
+
+// DanglingOperatorError is returned for a filter or group that ends with a
+// logical operator, like `$.a = b &&`. Span locates the operator in the parsed
+// filter.
+type DanglingOperatorError struct {
+	Operator LogicalOperator
+	Span     Span
+}
+
+func (e DanglingOperatorError) Error() string {
+	return "dangling logical operator " + string(e.Operator) + " at byte " + strconv.Itoa(e.Span.StartByte)
+}
+
+func (e DanglingOperatorError) Unwrap() error {
+	return ErrDanglingOperator
+}
+
+// AmbiguousPrecedenceError is returned, with the ExplicitPrecedence option, for
+// a group Expr mixing && and || without parenthesis, found at Span of the parsed
+// filter. It wraps ErrAlternatingLogicalOperators.
+type AmbiguousPrecedenceError struct {
+	Expr string
+	Span Span
+}
+
+func (e AmbiguousPrecedenceError) Error() string {
+	return "ambiguous mix of && and || in " + strconv.Quote(e.Expr) +
+		", add parenthesis around the clauses to join first, like (a && b) || c"
+}
+
+func (e AmbiguousPrecedenceError) Unwrap() error {
+	return ErrAlternatingLogicalOperators
+}
+
+// SubExpressionError is the failure to parse the sub expression between
+// parenthesis Expr, found at Span of the parsed filter. Nested sub expressions
+// wrap each other's errors, so the message traces the failure down to the
+// innermost group. ErrMaxDepthReached and ErrParseTimeout are never wrapped.
+type SubExpressionError struct {
+	Expr string
+	Span Span
+	Err  error
+}
+
+func (e SubExpressionError) Error() string {
+	return "in " + strconv.Quote(e.Expr) + ": " + e.Err.Error()
+}
+
+func (e SubExpressionError) Unwrap() error {
+	return e.Err
+}
+
+// LogicalOperator joins the expressions of a group
+type LogicalOperator string
+
+// ComparisonOperator compares the operands of a clause. Operators added by
+// RegisterComparisonOperator are ComparisonOperators too.
+type ComparisonOperator string
 
 const (
-	loAnd logicalOperator = "&&"
-	loOr  logicalOperator = "||"
+	And LogicalOperator = "&&"
+	Or  LogicalOperator = "||"
+
+	Equal     ComparisonOperator = "="
+	NotEqual  ComparisonOperator = "!="
+	NotExists ComparisonOperator = "NOT EXISTS"
+	In        ComparisonOperator = "IN"
+	NotIn     ComparisonOperator = "NOT IN"
 
-	coEqual     comparisonOperator = "="
-	coNotEqual  comparisonOperator = "!="
-	coNotExists comparisonOperator = "NOT EXISTS"
+	LessThan           ComparisonOperator = "<"
+	LessThanOrEqual    ComparisonOperator = "<="
+	GreaterThan        ComparisonOperator = ">"
+	GreaterThanOrEqual ComparisonOperator = ">="
+
+	// RegexMatch matches the selector against a regular expression, as some
+	// log analytics dialects do. CloudWatch doesn't support it.
+	RegexMatch ComparisonOperator = "=~"
 )
 
+// internal names of the operators, kept for backward compatibility
+type logicalOperator = LogicalOperator
+type comparisonOperator = ComparisonOperator
+
+const (
+	loAnd = And
+	loOr  = Or
+
+	coEqual     = Equal
+	coNotEqual  = NotEqual
+	coNotExists = NotExists
+	coIn        = In
+	coNotIn     = NotIn
+
+	coLessThan           = LessThan
+	coLessThanOrEqual    = LessThanOrEqual
+	coGreaterThan        = GreaterThan
+	coGreaterThanOrEqual = GreaterThanOrEqual
+
+	coRegexMatch = RegexMatch
+)
+
+// operatorAliases maps other tokens accepted for an operator, like SQL's `<>`,
+// to the operator they stand for. Expressions always render the operator.
+var operatorAliases = map[string]comparisonOperator{
+	"<>": coNotEqual,
+}
+
+// operatorTable is a snapshot of the comparison operators known to the parser.
+// It's never modified once published, registering an operator publishes a new
+// table instead, so a parse takes the current table once and scans without
+// locking.
+type operatorTable struct {
+	// operators is sorted by descending length so longer operators are always
+	// matched first, e.g. `<=` is never split into `<` and `=`
+	operators []comparisonOperator
+	// registered holds the operators added by RegisterComparisonOperator
+	registered map[comparisonOperator]bool
+	// aliases are the keys of operatorAliases, sorted by descending length
+	aliases []string
+	// starts tells which bytes start an operator or an alias
+	starts [256]bool
+}
+
+func newOperatorTable(operators []comparisonOperator, registered map[comparisonOperator]bool) *operatorTable {
+	t := &operatorTable{operators: sortByLength(operators), registered: registered}
+	for alias := range operatorAliases {
+		t.aliases = append(t.aliases, alias)
+		t.starts[alias[0]] = true
+	}
+	sort.SliceStable(t.aliases, func(i, j int) bool {
+		return len(t.aliases[i]) > len(t.aliases[j])
+	})
+
+	for _, op := range operators {
+		t.starts[op[0]] = true
+	}
+
+	return t
+}
+
+// builtinOperators is the operatorTable of the operators the package ships
+var builtinOperators = newOperatorTable([]comparisonOperator{
+	coEqual, coNotEqual, coNotExists, coIn, coNotIn,
+	coLessThan, coLessThanOrEqual, coGreaterThan, coGreaterThanOrEqual,
+	coRegexMatch,
+}, map[comparisonOperator]bool{})
+
+// operators is the current operatorTable, once an operator is registered
+var operators atomic.Pointer[operatorTable]
+
+// loadOperators returns the current operatorTable
+func loadOperators() *operatorTable {
+	if table := operators.Load(); table != nil {
+		return table
+	}
+
+	return builtinOperators
+}
+
+// operatorsMu serializes the registrations, which replace the operatorTable
+var operatorsMu sync.Mutex
+
+// RegisterComparisonOperator teaches the parser the comparison operator token,
+// for operators CloudWatch supports but this package doesn't ship yet. Clauses
+// with a registered operator are only equivalent when both of their operands
+// are exactly the same, as nothing is known about the operator.
+//
+// Registration is meant to be done at init time, filters parsed concurrently
+// may miss the new operator. It panics if token is already an operator or holds
+// quotes, parenthesis, braces or logical operators.
+func RegisterComparisonOperator(token string) {
+	op := comparisonOperator(strings.Join(strings.Fields(token), " "))
+	if len(op) == 0 || strings.ContainsAny(string(op), "\"(){}") ||
+		strings.Contains(string(op), string(loAnd)) || strings.Contains(string(op), string(loOr)) {
+		panic("cloudwatch_lep: invalid comparison operator " + strconv.Quote(token))
+	}
+
+	operatorsMu.Lock()
+	defer operatorsMu.Unlock()
+
+	table := loadOperators()
+	if _, ok := operatorAliases[string(op)]; ok || slices.Contains(table.operators, op) {
+		panic("cloudwatch_lep: comparison operator " + strconv.Quote(token) + " already registered")
+	}
+
+	registered := maps.Clone(table.registered)
+	registered[op] = true
+	operators.Store(newOperatorTable(append(slices.Clone(table.operators), op), registered))
+}
+
+// isRegistered tells if c was added by RegisterComparisonOperator
+func (c comparisonOperator) isRegistered() bool {
+	registered := loadOperators().registered
+	return len(registered) > 0 && registered[c]
+}
+
+// comparesExactly tells if clauses with the operator c are only equivalent when
+// their operands are exactly the same. That's the case of registered operators,
+// as nothing is known about them, and of =~, as telling if two regular
+// expressions match the same values is out of scope, so `$.a =~ "b|c"` and
+// `$.a =~ "c|b"` are different.
+func (c comparisonOperator) comparesExactly() bool {
+	return c == coRegexMatch || c.isRegistered()
+}
+
 func listLogicalOperators() []logicalOperator {
 	return []logicalOperator{loAnd, loOr}
 }
 
 func listComparisonOperator() []comparisonOperator {
-	// This order must be kept because we need to check first different and then equals
-	return []comparisonOperator{coNotExists, coNotEqual, coEqual}
+	return loadOperators().operators
+}
+
+func sortByLength(operators []comparisonOperator) []comparisonOperator {
+	sort.SliceStable(operators, func(i, j int) bool {
+		return len(operators[i]) > len(operators[j])
+	})
+
+	return operators
+}
+
+// isList tells if the operator takes a list of values, like `$.x IN [a, b]`
+func (c comparisonOperator) isList() bool {
+	return c == coIn || c == coNotIn
+}
+
+// isThreshold tells if the operator compares numbers, like `$.x > 5`
+func (c comparisonOperator) isThreshold() bool {
+	return c == coLessThan || c == coLessThanOrEqual || c == coGreaterThan || c == coGreaterThanOrEqual
+}
+
+// mirrored returns the operator to use when swapping the operands, as `a < b`
+// is the same as `b > a`
+func (c comparisonOperator) mirrored() comparisonOperator {
+	switch c {
+	case coLessThan:
+		return coGreaterThan
+	case coLessThanOrEqual:
+		return coGreaterThanOrEqual
+	case coGreaterThan:
+		return coLessThan
+	case coGreaterThanOrEqual:
+		return coLessThanOrEqual
+	}
+
+	return c
+}
+
+// Span is the byte range [StartByte, EndByte) of an expression in its source
+type Span struct {
+	StartByte int
+	EndByte   int
 }
 
-type expression interface {
-	isEquivalent(s expression) bool
+// Expression is a parsed CloudWatch filter expression.
+type Expression interface {
+	// Equals reports whether both expressions match the same log events.
+	// It mirrors the Equals method of the logic-expression-parser Expression.
+	Equals(o Expression) bool
+
+	// StructurallyEqual reports whether both expressions are written the same
+	// way: same groups, with their clauses in the same order, and clauses with
+	// the same operands on the same side. Unlike Equals, reordering clauses or
+	// swapping operands makes expressions different, which suits detecting
+	// changes. Spacing, redundant parenthesis and spans are ignored.
+	StructurallyEqual(o Expression) bool
+
+	// String renders the expression back to the filter syntax, without the
+	// surrounding braces. Sub expressions are always wrapped in parenthesis,
+	// so parsing the output gives back an equivalent expression and rendering
+	// it again gives the same string.
+	String() string
+
+	// Span locates the expression in the parsed source, it's empty for
+	// expressions that weren't parsed.
+	Span() Span
+
+	// Operator returns the comparison operator of a clause, like `=`, `<=` or
+	// `NOT EXISTS`, or the logical operator joining a group, `&&` or `||`.
+	// It's the same operator String renders, ClauseOperator and GroupOperator
+	// return it typed.
+	Operator() string
+
+	isEquivalent(s Expression) bool
+	isEquivalentWith(s Expression, opts CompareOptions) bool
+	structurallyEqual(s Expression) bool
 }
 
 type simpleExpression struct {
 	left     string
 	right    string
 	operator comparisonOperator
+	values   []string // list operand of the IN operator
+	span     Span
+}
+
+func (s simpleExpression) isEquivalent(o Expression) bool {
+	return s.isEquivalentWith(o, CompareOptions{})
 }
 
-func (s simpleExpression) isEquivalent(o expression) bool {
-	simpleOther, ok := any(o).(simpleExpression)
+func (s simpleExpression) isEquivalentWith(o Expression, opts CompareOptions) bool {
+	if complexOther, isComplex := unwrapSingle(o).(complexExpression); isComplex && !opts.StrictOrder {
+		return complexOther.isEquivalentWith(s, opts) // a group of duplicates of s is s
+	}
+
+	if s.operator.isList() {
+		return s.expanded().isEquivalentWith(o, opts)
+	}
+
+	simpleOther, ok := unwrapSingle(o).(simpleExpression)
 	if !ok {
 		return false // not a simpleExpression
 	}
 
-	if simpleOther.operator != s.operator {
-		return false
+	if simpleOther.operator.isList() {
+		return s.isEquivalentWith(simpleOther.expanded(), opts)
 	}
 
-	if simpleOther.left == s.left && simpleOther.right == s.right {
+	s, simpleOther = s.normalizedOperands(), simpleOther.normalizedOperands()
+	if s.operator.comparesExactly() || simpleOther.operator.comparesExactly() {
+		return s.operator == simpleOther.operator && s.left == simpleOther.left && s.right == simpleOther.right
+	}
+
+	a, b := s.selectorFirst(), simpleOther.selectorFirst()
+	if a.operator == b.operator && a.left == b.left && opts.valuesEqual(a.right, b.right) {
 		return true
 	}
 
-	if simpleOther.left == s.right && simpleOther.right == s.left {
+	if a.operator == b.operator.mirrored() && a.left == b.right && a.right == b.left {
 		return true
 	}
 
 	return false
 }
 
+// expanded rewrites `$.x IN [a, b]` as the equivalent `$.x = a || $.x = b`,
+// and `$.x NOT IN [a, b]` as `$.x != a && $.x != b`
+func (s simpleExpression) expanded() Expression {
+	operator, logicalOp := coEqual, loOr
+	if s.operator == coNotIn {
+		operator, logicalOp = coNotEqual, loAnd
+	}
+
+	expressions := make([]Expression, 0, len(s.values))
+	for _, v := range s.values {
+		expressions = appendUnique(expressions, simpleExpression{left: s.left, operator: operator, right: v})
+	}
+
+	if len(expressions) == 1 {
+		return expressions[0]
+	}
+
+	return complexExpression{operator: logicalOp, expressions: expressions}
+}
+
+// selectorFirst swaps the operands when the selector is on the right side.
+// Bare field names, like `eventName`, can't be told apart from values, so
+// clauses without a `$` selector are left as they are.
+func (s simpleExpression) selectorFirst() simpleExpression {
+	if strings.HasPrefix(s.right, "$") && !strings.HasPrefix(s.left, "$") {
+		return simpleExpression{left: s.right, operator: s.operator.mirrored(), right: s.left}
+	}
+
+	return s
+}
+
+// normalizedOperands rewrites the selector operands of s in dot notation,
+// see canonicalizeSelector, and the numbers compared by thresholds in their
+// shortest form, so `$.x < .5` and `$.x < 0.50` are the same clause
+func (s simpleExpression) normalizedOperands() simpleExpression {
+	s.left, s.right = canonicalizeSelector(s.left), canonicalizeSelector(s.right)
+	if s.operator.isThreshold() {
+		s.left, s.right = canonicalizeNumber(s.left), canonicalizeNumber(s.right)
+	}
+
+	return s
+}
+
+// canonicalizeNumber returns the unquoted number v in its shortest form, other
+// operands as they are
+func canonicalizeNumber(v string) string {
+	if !isNumber(v) {
+		return v
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (s simpleExpression) Equals(o Expression) bool {
+	return s.isEquivalent(o)
+}
+
+func (s simpleExpression) StructurallyEqual(o Expression) bool {
+	return s.structurallyEqual(o)
+}
+
+func (s simpleExpression) structurallyEqual(o Expression) bool {
+	simpleOther, ok := o.(simpleExpression)
+	return ok && s.left == simpleOther.left && s.operator == simpleOther.operator &&
+		s.right == simpleOther.right && slices.Equal(s.values, simpleOther.values)
+}
+
+func (s simpleExpression) Span() Span {
+	return s.span
+}
+
+func (s simpleExpression) Operator() string {
+	return string(s.operator)
+}
+
+func (s simpleExpression) String() string {
+	if s.operator == coNotExists {
+		return s.left + " " + string(s.operator)
+	}
+
+	if s.operator.isList() {
+		return s.left + " " + string(s.operator) + " [" + strings.Join(s.values, ", ") + "]"
+	}
+
+	return s.left + " " + string(s.operator) + " " + s.right
+}
+
 type complexExpression struct {
 	operator    logicalOperator
-	expressions []expression
+	expressions []Expression
+	span        Span
+	hash        uint64 // Hash of the group, once computed while reducing its parent
+	form        string // canonicalForm of the group, once reduced with a cache
+}
+
+func (c complexExpression) isEquivalent(o Expression) bool {
+	return c.isEquivalentWith(o, CompareOptions{})
+}
+
+func (c complexExpression) isEquivalentWith(o Expression, opts CompareOptions) bool {
+	if opts.StrictOrder {
+		return c.matchesInOrder(o, opts)
+	}
+
+	return equivalentReduced(reduced(c, opts), reduced(o, opts), opts)
 }
 
-func (c complexExpression) isEquivalent(o expression) bool {
+// matchesInOrder tells if the expressions of c are equivalent to the ones of o
+// at the same positions
+func (c complexExpression) matchesInOrder(o Expression, opts CompareOptions) bool {
+	if len(c.expressions) == 1 {
+		return c.expressions[0].isEquivalentWith(o, opts)
+	}
+
+	o = unwrapSingle(o)
+	if simpleOther, ok := any(o).(simpleExpression); ok && simpleOther.operator.isList() {
+		return c.matchesInOrder(simpleOther.expanded(), opts)
+	}
+
 	complexOther, ok := any(o).(complexExpression)
 	if !ok {
 		return false // not a complexExpression
 	}
 
+	c, complexOther = c.flattened().withExpandedIn(), complexOther.flattened().withExpandedIn()
+	if complexOther.operator != c.operator || len(c.expressions) != len(complexOther.expressions) {
+		return false
+	}
+
+	for i, exp := range c.expressions {
+		if !exp.isEquivalentWith(complexOther.expressions[i], opts) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reduced rewrites e, at every level, in the form groups are compared in: IN
+// lists expanded, nested groups of the same operator inlined, duplicated
+// expressions dropped, as `a && a` matches the same log events as `a`, and
+// groups left with a single expression replaced by it. So
+// `$.a = x || ($.b = y && $.b = y)` compares as `$.a = x || $.b = y`.
+func reduced(e Expression, opts CompareOptions) Expression {
+	switch exp := unwrapSingle(e).(type) {
+	case simpleExpression:
+		if exp.operator.isList() {
+			return reduced(exp.expanded(), opts)
+		}
+
+		return exp
+	case complexExpression:
+		expressions := make([]Expression, 0, len(exp.expressions))
+		for _, sub := range exp.expressions {
+			expressions = append(expressions, reduced(sub, opts))
+		}
+
+		c := complexExpression{operator: exp.operator, expressions: expressions}.flattened().withoutDuplicates(opts)
+		if len(c.expressions) == 1 {
+			return c.expressions[0]
+		}
+
+		if opts.cache != nil {
+			c.form = canonicalForm(c)
+		}
+
+		return c
+	}
+
+	return e
+}
+
+// equivalentReduced tells if a and b, both reduced, are equivalent
+func equivalentReduced(a, b Expression, opts CompareOptions) bool {
+	c, okA := a.(complexExpression)
+	complexOther, okB := b.(complexExpression)
+	if !okA && !okB {
+		return a.isEquivalentWith(b, opts)
+	}
+
+	if !okA || !okB {
+		return false // a reduced group has distinct expressions, it's never a single clause
+	}
+
+	// Big OR groups of the same selector can be compared as sorted sets
+	if selector, values, ok := c.equalsSet(opts); ok {
+		if otherSelector, otherValues, ok := complexOther.equalsSet(opts); ok && selector == otherSelector {
+			return slices.Equal(slices.Compact(values), slices.Compact(otherValues))
+		}
+	}
+
 	if complexOther.operator != c.operator {
 		return false
 	}
@@ -78,17 +585,177 @@ func (c complexExpression) isEquivalent(o expression) bool {
 		return false
 	}
 
-	otherExpressions := make([]expression, len(complexOther.expressions))
-	copy(otherExpressions, complexOther.expressions)
+	return opts.cache.remember(c, complexOther, opts, func() bool {
+		return c.matchesUnordered(complexOther, opts)
+	})
+}
+
+// matchesUnordered tells if each expression of c has an equivalent one in
+// other, whatever their order. Both groups must be reduced.
+func (c complexExpression) matchesUnordered(other complexExpression, opts CompareOptions) bool {
+	expressions, otherExpressions := c.expressions, slices.Clone(other.expressions)
+	if opts.exact() {
+		// Simple clauses are paired through their keys, the rest is scanned below
+		expressions, otherExpressions = pairByKey(expressions, otherExpressions)
+	}
+
+	// only the expressions with the same hash may be equivalent
+	hashes := make([]uint64, len(otherExpressions))
+	for i, exp := range otherExpressions {
+		hashes[i] = opts.hash(exp)
+	}
 
+	for _, exp := range expressions {
+		hash := opts.hash(exp)
+		idx := -1
+		for i, other := range otherExpressions {
+			if hashes[i] == hash && equivalentReduced(exp, other, opts) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return false // no equivalent expression found
+		}
+
+		// Replace the found index by the last position, which is then dropped
+		last := len(otherExpressions) - 1
+		otherExpressions[idx], hashes[idx] = otherExpressions[last], hashes[last]
+		otherExpressions, hashes = otherExpressions[:last], hashes[:last]
+	}
+
+	return true
+}
+
+// withoutDuplicates drops the expressions of c, already reduced, equivalent to
+// an earlier one. Simple clauses are compared through their keys when values
+// are compared as they are written, and other expressions only with the ones
+// of the same hash.
+func (c complexExpression) withoutDuplicates(opts CompareOptions) complexExpression {
+	expressions := make([]Expression, 0, len(c.expressions))
+	hashes := make([]uint64, 0, len(c.expressions)) // of expressions
+	keys := make(map[string]bool, len(c.expressions))
 	for _, exp := range c.expressions {
-		if found, idx := c.findEquivalentPos(exp, otherExpressions); found {
-			// Replace the found index by the last position
-			otherExpressions[idx] = otherExpressions[len(otherExpressions)-1]
-			// Replace the last position (now it's duplicated)
-			otherExpressions = otherExpressions[:len(otherExpressions)-1]
+		if key, ok := clauseKey(exp); ok && opts.exact() {
+			if !keys[key] {
+				keys[key] = true
+				expressions, hashes = append(expressions, exp), append(hashes, hashString(key))
+			}
+			continue
+		}
+
+		hash := opts.hash(exp)
+		if group, ok := exp.(complexExpression); ok {
+			group.hash = hash // kept for pairing the group, and hashing c
+			exp = group
+		}
+
+		duplicated := false
+		for i, other := range expressions {
+			if hashes[i] == hash && equivalentReduced(other, exp, opts) {
+				duplicated = true
+				break
+			}
+		}
+
+		if !duplicated {
+			expressions, hashes = append(expressions, exp), append(hashes, hash)
+		}
+	}
+
+	return complexExpression{operator: c.operator, expressions: expressions, span: c.span}
+}
+
+// flattened inlines the nested groups joined by the same operator, so
+// `(a || b) || c` and `a || (b || c)` both compare as `a || b || c`
+func (c complexExpression) flattened() complexExpression {
+	expressions := make([]Expression, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		sub, ok := unwrapSingle(exp).(complexExpression)
+		if !ok || sub.operator != c.operator {
+			expressions = append(expressions, exp)
+			continue
+		}
+
+		expressions = append(expressions, sub.flattened().expressions...)
+	}
+
+	return complexExpression{operator: c.operator, expressions: expressions}
+}
+
+// withExpandedIn inlines the IN clauses of an OR group as equal clauses, so
+// `$.x IN [a, b] || $.y = c` compares as `$.x = a || $.x = b || $.y = c`, and
+// likewise the NOT IN clauses of an AND group as not equal clauses
+func (c complexExpression) withExpandedIn() complexExpression {
+	inlined := coIn
+	if c.operator == loAnd {
+		inlined = coNotIn
+	}
+
+	expressions := make([]Expression, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		s, ok := unwrapSingle(exp).(simpleExpression)
+		if !ok || s.operator != inlined {
+			expressions = append(expressions, exp)
+			continue
+		}
+
+		if sub, ok := s.expanded().(complexExpression); ok {
+			expressions = append(expressions, sub.expressions...)
 		} else {
-			// if no equivalent expression found, return falses
+			expressions = append(expressions, s.expanded())
+		}
+	}
+
+	return complexExpression{operator: c.operator, expressions: expressions}
+}
+
+// equalsSet returns the selector, in dot notation, and the sorted values of an
+// OR group made only of equal clauses over the same selector, like
+// `$.eventName = A || $["eventName"] = B`
+func (c complexExpression) equalsSet(opts CompareOptions) (string, []string, bool) {
+	if c.operator != loOr {
+		return "", nil, false
+	}
+
+	var selector string
+	values := make([]string, 0, len(c.expressions))
+	for i, exp := range c.expressions {
+		s, ok := unwrapSingle(exp).(simpleExpression)
+		if !ok || s.operator != coEqual {
+			return "", nil, false
+		}
+
+		s = s.selectorFirst()
+		if left := canonicalizeSelector(s.left); i == 0 {
+			selector = left
+		} else if left != selector {
+			return "", nil, false
+		}
+
+		values = append(values, opts.valueKey(s.right))
+	}
+
+	sort.Strings(values)
+	return selector, values, true
+}
+
+func (c complexExpression) Equals(o Expression) bool {
+	return c.isEquivalent(o)
+}
+
+func (c complexExpression) StructurallyEqual(o Expression) bool {
+	return c.structurallyEqual(o)
+}
+
+func (c complexExpression) structurallyEqual(o Expression) bool {
+	complexOther, ok := o.(complexExpression)
+	if !ok || c.operator != complexOther.operator || len(c.expressions) != len(complexOther.expressions) {
+		return false
+	}
+
+	for i, exp := range c.expressions {
+		if !exp.structurallyEqual(complexOther.expressions[i]) {
 			return false
 		}
 	}
@@ -96,9 +763,99 @@ func (c complexExpression) isEquivalent(o expression) bool {
 	return true
 }
 
-func (c complexExpression) findEquivalentPos(exp expression, otherExpressions []expression) (bool, int) {
+func (c complexExpression) Span() Span {
+	return c.span
+}
+
+func (c complexExpression) Operator() string {
+	return string(c.operator)
+}
+
+func (c complexExpression) String() string {
+	parts := make([]string, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		if _, ok := exp.(complexExpression); ok {
+			parts = append(parts, "("+exp.String()+")")
+		} else {
+			parts = append(parts, exp.String())
+		}
+	}
+
+	return strings.Join(parts, " "+string(c.operator)+" ")
+}
+
+// pairByKey matches the simple clauses of a and b with the same key, returning
+// the expressions left to pair. Clauses without a match by key are left too, as
+// one may still be equivalent to a clause that has no key, like an IN list.
+func pairByKey(a, b []Expression) ([]Expression, []Expression) {
+	byKey := make(map[string][]Expression, len(b))
+	keysB := make([]string, len(b)) // empty for the expressions without key
+	restB := make([]Expression, 0, len(b))
+	for i, exp := range b {
+		if key, ok := clauseKey(exp); ok {
+			byKey[key] = append(byKey[key], exp)
+			keysB[i] = key
+		} else {
+			restB = append(restB, exp)
+		}
+	}
+
+	restA := make([]Expression, 0, len(restB))
+	for _, exp := range a {
+		key, ok := clauseKey(exp)
+		if !ok || len(byKey[key]) == 0 {
+			restA = append(restA, exp)
+			continue
+		}
+
+		byKey[key] = byKey[key][1:]
+	}
+
+	for _, key := range keysB {
+		if key != "" && len(byKey[key]) > 0 {
+			restB = append(restB, byKey[key][0])
+			byKey[key] = byKey[key][1:]
+		}
+	}
+
+	return restA, restB
+}
+
+// clauseKey identifies a simple clause regardless of the order of its
+// operands, so two clauses are equivalent when their keys are the same
+func clauseKey(e Expression) (string, bool) {
+	s, ok := unwrapSingle(e).(simpleExpression)
+	if !ok || s.operator.isList() {
+		return "", false
+	}
+
+	s = s.normalizedOperands()
+	left, operator, right := s.left, s.operator, s.right
+	if left > right && !operator.comparesExactly() {
+		left, operator, right = right, operator.mirrored(), left
+	}
+
+	return string(operator) + "\x00" + left + "\x00" + right, true
+}
+
+// unwrapSingle returns the only child of a group of one expression, which
+// matches the same log events as the child itself
+func unwrapSingle(e Expression) Expression {
+	for {
+		c, ok := e.(complexExpression)
+		if !ok || len(c.expressions) != 1 {
+			return e
+		}
+
+		e = c.expressions[0]
+	}
+}
+
+// findEquivalentPos returns the position of the first expression of
+// otherExpressions equivalent to exp, if any
+func findEquivalentPos(exp Expression, otherExpressions []Expression, opts CompareOptions) (bool, int) {
 	for i, expB := range otherExpressions {
-		if exp.isEquivalent(expB) {
+		if exp.isEquivalentWith(expB, opts) {
 			return true, i
 		}
 	}
@@ -107,111 +864,363 @@ func (c complexExpression) findEquivalentPos(exp expression, otherExpressions []
 }
 
 func areCloudWatchExpressionsEquivalent(a, b string) (bool, error) {
-	statementA, err := parse(a)
-	if err != nil {
-		return false, err
-	}
+	return EquivalentWithOptions(a, b, CompareOptions{})
+}
+
+// Parse parses the CloudWatch filter s, like `{ $.eventName = ConsoleLogin }`.
+// Without options it accepts up to 5 levels of nested parenthesis and 65536
+// runes, both logical operators, any selector and keeps quotes and comments as
+// they are written. Bare field names, as in `eventName = ConsoleLogin`, are
+// selectors too, see WithRequireDollarSelectors for CloudWatch's stricter rule.
+// The braces around the filter are optional, but only a single pair is accepted.
+func Parse(s string, opts ...Option) (Expression, error) {
+	return parseWith(s, newParseOptions(opts))
+}
 
-	statementB, err := parse(b)
+// MustParse is like Parse without options but panics if s can't be parsed. It
+// simplifies the initialization of variables holding known filters.
+func MustParse(s string) Expression {
+	exp, err := parse(s)
 	if err != nil {
-		return false, err
+		panic("cloudwatch_lep: Parse(" + strconv.Quote(s) + "): " + err.Error())
 	}
 
-	return statementA.isEquivalent(statementB), nil
+	return exp
+}
+
+func parse(s string) (Expression, error) {
+	return parseWith(s, ParseOptions{})
 }
 
-func parse(s string) (expression, error) {
-	// remove trailing spaces and { }
-	cleanS := strings.TrimSpace(strings.TrimRight(strings.TrimLeft(strings.TrimSpace(s), "{"), "}"))
+func parseWith(s string, opts ParseOptions) (Expression, error) {
+	if utf8.RuneCountInString(s) > opts.maxInputLength() {
+		return nil, ErrInputTooLarge
+	}
+
+	if opts.Timeout > 0 {
+		opts.deadline = time.Now().Add(opts.Timeout)
+	}
+	opts.operators = loadOperators()
+
+	if opts.StripTrailingComment {
+		s = stripTrailingComment(s)
+	}
+
+	if isBlank(s) {
+		return nil, ErrEmptyExpression
+	}
 
-	if strings.Count(s, "(") != strings.Count(s, ")") {
+	if !IsJSONPattern(s) {
+		return nil, ErrNotJSONPattern
+	}
+
+	if countUnquoted(s, '(') != countUnquoted(s, ')') {
 		return nil, errors.New("broken parenthesis")
 	}
 
-	return safeParse(cleanS, 0)
+	if hasUnterminatedQuote(s) {
+		return nil, errors.New("unterminated quoted string")
+	}
+
+	if countUnquoted(s, '{') != countUnquoted(s, '}') {
+		return nil, errors.New("unbalanced braces")
+	}
+
+	// remove trailing spaces and a single pair of { }, keeping track of where
+	// the expression is
+	start, end := trimSpan(s, 0, len(s))
+	if start < end && s[start] == '{' && s[end-1] == '}' {
+		start, end = trimSpan(s, start+1, end-1)
+	}
+
+	if start < end && s[start] == '{' && s[end-1] == '}' {
+		return nil, errors.New("multiple braces around expression")
+	}
+
+	if start == end {
+		return nil, ErrEmptyExpression
+	}
+
+	if countUnquoted(s[start:end], '{')+countUnquoted(s[start:end], '}') > 0 {
+		return nil, errors.New("unexpected brace inside expression")
+	}
+
+	return safeParse(s, start, end, 0, opts)
 }
 
-func safeParse(s string, depth int) (expression, error) {
-	if depth > maxDepth {
-		return nil, errors.New("max depth reached, can't parse this expression")
+// IsJSONPattern tells if s is a filter of the `{ $.eventName = ConsoleLogin }`
+// form this package handles, rather than a space-delimited term pattern like
+// `ERROR -INFO` or `[ip, user, status=4*]`. Clauses without braces are JSON
+// patterns as long as they hold a selector like `$.eventName` or a comparison
+// like `a = b` outside quotes.
+func IsJSONPattern(s string) bool {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") {
+		return true
 	}
 
-	var logicalOp logicalOperator
-	expressions := make([]expression, 0, 10)
+	if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+		return false // space-delimited fields, which may hold comparisons
+	}
+
+	table := loadOperators()
+	quotes := quoteState{}
+	for i := 0; i < len(s); i++ {
+		if quotes.next(s[i]) {
+			continue
+		}
+
+		if length, _ := table.matchAt(s, i); length > 0 {
+			return true
+		}
+
+		if s[i] == '$' && i+1 < len(s) && (s[i+1] == '.' || s[i+1] == '[') {
+			return true
+		}
+	}
+
+	return false
+}
+
+// safeParse parses s[start:end]. The whole source is passed along so the spans
+// of the parsed expressions are offsets into it.
+func safeParse(s string, start, end int, depth int, opts ParseOptions) (Expression, error) {
+	if depth > opts.maxDepth() {
+		return nil, ErrMaxDepthReached
+	}
+
+	if opts.timedOut() {
+		return nil, ErrParseTimeout
+	}
 
-	buf := strings.Builder{}
-	buf.Grow(len(s))
+	var logicalOp logicalOperator
+	var lastOp Span // where the last logical operator is
+	expressions := make([]Expression, 0, 10)
+	expectingOp := false // a sub expression must be followed by a logical operator
+	clauseStart := start // where the clause being scanned begins
 
-	pointer := 0
-	for len(s) > pointer {
-		r := rune(s[pointer])
-		i := pointer
-		pointer++
+	quotes := quoteState{}
+	for i := start; i < end; i++ {
+		if quotes.next(s[i]) { // Everything between quotes is part of a value
+			continue
+		}
 
-		if r == '(' { // If it's a parenthesis opening, resolve the parenthesis
-			pos := matchingParenthesisPos(s[i:])
+		if s[i] == '(' { // If it's a parenthesis opening, resolve the parenthesis
+			pos := matchingParenthesisPos(s[i:end])
 			if pos < 0 {
 				return nil, errors.New("broken parenthesis")
 			}
 
-			subS := s[i+1 : pos+i]
-			exp, err := safeParse(subS, depth+1)
+			subStart, subEnd, ok := unwrapParenthesis(s, i+1, i+pos, opts.maxDepth())
+			if !ok {
+				return nil, ErrMaxDepthReached
+			}
+
+			// a parenthesized operand, like `$.x = (foo)` or `(foo) = $.x`, is part
+			// of the clause
+			if isParenthesizedValue(s[subStart:subEnd], opts.operators) &&
+				(!isBlank(s[clauseStart:i]) || startsWithComparisonOp(s[i+pos+1:end], opts.operators)) {
+				i += pos
+				continue
+			}
+
+			if expectingOp || !isBlank(s[clauseStart:i]) {
+				return nil, errors.New("missing logical operator between expressions")
+			}
+
+			exp, err := safeParse(s, subStart, subEnd, depth+1, opts)
+			if errors.Is(err, ErrMaxDepthReached) || errors.Is(err, ErrParseTimeout) {
+				return nil, err // the whole filter is at fault, not a sub expression
+			}
 			if err != nil {
-				return nil, err
+				return nil, SubExpressionError{Expr: s[subStart:subEnd], Span: Span{StartByte: subStart, EndByte: subEnd}, Err: err}
 			}
 			expressions = append(expressions, exp)
-			pointer = pos + i + 1 // move pointer to the end of what has been already processed
+			expectingOp = true
+			i += pos // move to the end of what has been already processed
+			clauseStart = i + 1
 			continue
 		}
 
-		buf.WriteRune(r)
+		contains, op := hasPrefixLogicalOp(s[i:end])
+		if !contains {
+			continue
+		}
 
-		tmpString := buf.String()
-		if contains, op := hasSuffixLogicalOp(tmpString); contains {
-			if logicalOp == "" {
-				logicalOp = op
-			}
+		if !opts.allowsLogicalOp(op) {
+			return nil, errors.New("logical operator " + string(op) + " is not allowed")
+		}
 
-			if logicalOp != op {
-				return nil, errors.New("not supported comparison with alternating logical operators")
-			}
+		if logicalOp == "" {
+			logicalOp = op
+		}
 
-			expStr := strings.TrimSpace(strings.TrimSuffix(tmpString, string(op)))
-			// if the length is zero it means we had an already processed complex expressions (between parenthesis)
-			if len(expStr) > 0 {
-				exp, err := parseSimpleStatement(expStr)
-				if err != nil {
-					return nil, err
-				}
+		if logicalOp != op && opts.ExplicitPrecedence {
+			exprStart, exprEnd := trimSpan(s, start, end)
+			return nil, AmbiguousPrecedenceError{Expr: s[exprStart:exprEnd], Span: Span{StartByte: exprStart, EndByte: exprEnd}}
+		}
+
+		if logicalOp != op {
+			return nil, ErrAlternatingLogicalOperators
+		}
+
+		// if the clause is blank it means we had an already processed complex expressions (between parenthesis)
+		if isBlank(s[clauseStart:i]) {
+			if !expectingOp {
+				return nil, errors.New("missing expression around logical operator")
+			}
+		} else {
+			if expectingOp {
+				return nil, errors.New("missing logical operator between expressions")
+			}
 
-				expressions = append(expressions, exp)
+			exp, err := parseSimpleStatementAt(s, clauseStart, i, opts)
+			if err != nil {
+				return nil, err
 			}
 
-			buf.Reset()
-			buf.Grow(len(s) - i)
+			expressions = append(expressions, exp)
 		}
+
+		expectingOp = false
+		lastOp = Span{StartByte: i, EndByte: i + len(op)}
+		i += len(op) - 1
+		clauseStart = i + 1
 	}
 
-	expStr := strings.TrimSpace(buf.String())
-	if len(expStr) > 0 {
-		exp, err := parseSimpleStatement(expStr)
+	if !isBlank(s[clauseStart:end]) {
+		if expectingOp {
+			return nil, errors.New("missing logical operator between expressions")
+		}
+
+		exp, err := parseSimpleStatementAt(s, clauseStart, end, opts)
 		if err != nil {
 			return nil, err
 		}
 
 		expressions = append(expressions, exp)
+	} else if logicalOp != "" && !expectingOp {
+		return nil, DanglingOperatorError{Operator: logicalOp, Span: lastOp}
+	}
+
+	if len(expressions) == 0 {
+		return nil, ErrEmptyExpression
 	}
 
 	if len(expressions) == 1 { // unwrap simple expressions
 		return expressions[0], nil
 	}
 
-	return complexExpression{operator: logicalOp, expressions: expressions}, nil
+	start, end = trimSpan(s, start, end)
+	return complexExpression{operator: logicalOp, expressions: expressions, span: Span{StartByte: start, EndByte: end}}, nil
+}
+
+// trimSpan narrows s[start:end] to exclude leading and trailing white space
+func trimSpan(s string, start, end int) (int, int) {
+	sub := s[start:end]
+	start += len(sub) - len(strings.TrimLeftFunc(sub, unicode.IsSpace))
+	end -= len(sub) - len(strings.TrimRightFunc(sub, unicode.IsSpace))
+	if end < start {
+		return start, start
+	}
+
+	return start, end
+}
+
+// unwrapParenthesis narrows s[start:end] while it's wrapped by a redundant pair
+// of parenthesis, so `((a=b))` only takes one level of depth. At most limit
+// pairs are unwrapped, false is returned when more wrap s[start:end].
+func unwrapParenthesis(s string, start, end, limit int) (int, int, bool) {
+	start, end = trimSpan(s, start, end)
+
+	// the parenthesis s[start:end] starts with, one inside the other, and where
+	// they close, found in a single pass
+	var opening, closing []int
+	for i := start; i < end; {
+		r, size := utf8.DecodeRuneInString(s[i:end])
+		if r != '(' && !unicode.IsSpace(r) {
+			break
+		}
+
+		if r == '(' {
+			opening, closing = append(opening, i), append(closing, -1)
+		}
+		i += size
+	}
+
+	nested, quotes := 0, quoteState{}
+	for i := start; len(opening) > 0 && i < end && closing[0] < 0; i++ {
+		switch {
+		case quotes.next(s[i]):
+		case s[i] == '(':
+			nested++
+		case s[i] == ')':
+			nested--
+			if nested < len(closing) && closing[nested] < 0 {
+				closing[nested] = i
+			}
+		}
+	}
+
+	for pairs := 0; pairs < len(opening) && opening[pairs] == start && closing[pairs] == end-1; pairs++ {
+		if pairs == limit {
+			return start, end, false
+		}
+
+		start, end = trimSpan(s, start+1, end-1)
+	}
+
+	return start, end, true
+}
+
+// isParenthesizedValue tells if s, found between parenthesis and unwrapped,
+// is a value rather than a sub expression, as it has no operator
+func isParenthesizedValue(s string, operators *operatorTable) bool {
+	if isBlank(s) || countUnquoted(s, '(') > 0 || countUnquoted(s, ')') > 0 {
+		return false
+	}
+
+	if pos, _, _ := operators.findComparisonOp(s); pos >= 0 {
+		return false
+	}
+
+	quotes := quoteState{}
+	for i := 0; i < len(s); i++ {
+		if contains, _ := hasPrefixLogicalOp(s[i:]); !quotes.next(s[i]) && contains {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startsWithComparisonOp tells if s starts with a comparison operator, after
+// white space
+func startsWithComparisonOp(s string, operators *operatorTable) bool {
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	pos, _, _ := operators.findComparisonOp(trimmed)
+	return pos == 0
+}
+
+// unwrapOperand trims the spaces and up to limit pairs of parenthesis around
+// the operand s, so `(foo)` is the value foo, see unwrapParenthesis
+func unwrapOperand(s string, limit int) (string, bool) {
+	start, end, ok := unwrapParenthesis(s, 0, len(s), limit)
+	return s[start:end], ok
+}
+
+func isBlank(s string) bool {
+	return len(strings.TrimSpace(s)) == 0
 }
 
 func matchingParenthesisPos(s string) int {
 	parenthesisStack := 0
+	quotes := quoteState{}
 	for i, r := range s {
+		if quotes.next(s[i]) {
+			continue
+		}
+
 		if r == '(' {
 			parenthesisStack++
 		}
@@ -228,59 +1237,292 @@ func matchingParenthesisPos(s string) int {
 	return -1
 }
 
-func parseSimpleStatement(s string) (expression, error) {
-	buf := strings.Builder{}
-	buf.Grow(len(s))
+// quoteState tracks if a scanner is inside a quoted string, honoring \" escapes
+type quoteState struct {
+	inQuote bool
+	escaped bool
+}
+
+// next feeds the scanner with the byte b and tells if b belongs to a quoted
+// string, quotes included
+func (q *quoteState) next(b byte) bool {
+	if q.escaped {
+		q.escaped = false
+		return true
+	}
 
-	var left string
-	var operator comparisonOperator
-	foundOp := false
+	if q.inQuote && b == '\\' {
+		q.escaped = true
+		return true
+	}
 
-	for i, r := range s {
-		if buf.Len() == 0 && (r == ' ' || r == '(') { //ignore trailing spaces and (
-			continue
-		}
+	if b == '"' {
+		q.inQuote = !q.inQuote
+		return true
+	}
 
-		buf.WriteRune(r)
-		tmpString := buf.String()
-		if contains, op := hasSuffixComparisonOp(tmpString); contains {
-			if foundOp {
-				return nil, errors.New("got multiple comparison operators")
-			}
+	return q.inQuote
+}
 
-			left = strings.TrimSpace(strings.TrimSuffix(tmpString, string(op)))
-			operator = op
-			foundOp = true
-			buf.Reset()
-			buf.Grow(len(s) - i)
+func countUnquoted(s string, c byte) int {
+	count := 0
+	quotes := quoteState{}
+	for i := 0; i < len(s); i++ {
+		if !quotes.next(s[i]) && s[i] == c {
+			count++
 		}
 	}
 
-	if !foundOp {
+	return count
+}
+
+func hasUnterminatedQuote(s string) bool {
+	quotes := quoteState{}
+	for i := 0; i < len(s); i++ {
+		quotes.next(s[i])
+	}
+
+	return quotes.inQuote
+}
+
+func parseSimpleStatement(s string) (Expression, error) {
+	return parseSimpleStatementAt(s, 0, len(s), ParseOptions{operators: loadOperators()})
+}
+
+// parseSimpleStatementAt parses the clause s[start:end]
+func parseSimpleStatementAt(src string, start, end int, opts ParseOptions) (Expression, error) {
+	if opts.timedOut() {
+		return nil, ErrParseTimeout
+	}
+
+	// Trim trailing spaces and parenthesis around the statement
+	start, end, ok := unwrapParenthesis(src, start, end, opts.maxDepth())
+	if !ok {
+		return nil, ErrMaxDepthReached
+	}
+
+	s := src[start:end]
+	span := Span{StartByte: start, EndByte: end}
+
+	pos, length, operator := opts.operators.findComparisonOp(s)
+	if pos < 0 {
 		return nil, errors.New("could not find a operator for this expression")
 	}
 
-	// Trim trailing spaces and )
-	right := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(buf.String()), ")"))
-	return simpleExpression{
+	// Only structural whitespace is trimmed: a quoted operand starts and ends
+	// with its quotes, so the spaces inside them are always kept
+	left, leftOk := unwrapOperand(s[:pos], opts.maxDepth())
+	right, rightOk := unwrapOperand(s[pos+length:], opts.maxDepth())
+	if !leftOk || !rightOk {
+		return nil, ErrMaxDepthReached
+	}
+
+	if hasMisplacedComparisonOp(right, opts.operators) {
+		return nil, errors.New("got multiple comparison operators")
+	}
+
+	if left == "" {
+		return nil, ErrEmptyLeftOperand
+	}
+
+	if operator == coNotExists && len(right) > 0 {
+		return nil, errors.New("unexpected value after NOT EXISTS")
+	}
+
+	if opts.StrictSelectors && !isSelector(left) {
+		return nil, errors.New("expected a selector like $.eventName")
+	}
+
+	if opts.RequireDollarSelectors && !strings.HasPrefix(left, "$") {
+		return nil, errors.New("expected a selector starting with $")
+	}
+
+	if operator.isList() {
+		values, err := parseList(right)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.NormalizeQuotes {
+			for i, v := range values {
+				values[i] = unquoteWord(v)
+			}
+		}
+
+		return opts.clauseParsed(simpleExpression{left: left, operator: operator, values: values, span: span}), nil
+	}
+
+	if opts.NormalizeQuotes {
+		right = unquoteWord(right)
+	}
+
+	return opts.clauseParsed(simpleExpression{
 		left:     left,
 		operator: operator,
 		right:    right,
-	}, nil
+		span:     span,
+	}), nil
 }
 
-func hasSuffixComparisonOp(s string) (bool, comparisonOperator) {
-	for _, op := range listComparisonOperator() {
-		if strings.HasSuffix(s, string(op)) {
-			return true, op
+// parseList parses the `[a, "b", c]` operand of the IN operator
+func parseList(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, errors.New("expected a list of values like [a, b]")
+	}
+
+	values := make([]string, 0, strings.Count(s, ",")+1)
+	start := 1
+	quotes := quoteState{}
+	for i := 1; i < len(s); i++ {
+		if quotes.next(s[i]) {
+			continue
+		}
+
+		if s[i] == ',' || i == len(s)-1 {
+			v := strings.TrimSpace(s[start:i])
+			if len(v) == 0 {
+				return nil, errors.New("empty value in list")
+			}
+
+			values = append(values, v)
+			start = i + 1
 		}
 	}
-	return false, ""
+
+	return values, nil
+}
+
+// findComparisonOp scans s for the first comparison operator token outside
+// quotes, returning its byte position, its length as written and the operator,
+// or -1 if there is none. It uses the current operatorTable.
+func findComparisonOp(s string) (int, int, comparisonOperator) {
+	return loadOperators().findComparisonOp(s)
+}
+
+// findComparisonOp is like the package findComparisonOp with the operators of t
+func (t *operatorTable) findComparisonOp(s string) (int, int, comparisonOperator) {
+	quotes := quoteState{}
+	for i := 0; i < len(s); i++ {
+		if quotes.next(s[i]) {
+			continue
+		}
+
+		if length, op := t.matchAt(s, i); length > 0 {
+			return i, length, op
+		}
+	}
+
+	return -1, 0, ""
+}
+
+// matchAt returns the length and the operator of the comparison operator
+// written at s[pos:], or -1 if there is none
+func (t *operatorTable) matchAt(s string, pos int) (int, comparisonOperator) {
+	if !t.starts[s[pos]] {
+		return -1, ""
+	}
+
+	// aliases go first, as they may start like an operator: `<>` and `<`
+	for _, alias := range t.aliases {
+		if strings.HasPrefix(s[pos:], alias) {
+			return len(alias), operatorAliases[alias]
+		}
+	}
+
+	for _, op := range t.operators {
+		if length := matchComparisonOp(s, pos, op); length > 0 {
+			return length, op
+		}
+	}
+
+	return -1, ""
+}
+
+// hasMisplacedComparisonOp tells if the value v, found after the operator of a
+// clause, holds a comparison operator that can't be part of it: one starting
+// it, as in `$.a == b`, standing apart from the word before it, as in
+// `$.a != b !=`, or following a selector, as in `$.a=b&$.c=d`. Operators
+// attached to a word, like in `$.a = b=c` or `$.a = b!=`, are part of the
+// value.
+func hasMisplacedComparisonOp(v string, operators *operatorTable) bool {
+	offset := 0
+	for {
+		pos, length, _ := operators.findComparisonOp(v[offset:])
+		if pos < 0 {
+			return false
+		}
+
+		before := v[:offset+pos]
+		if before == "" || strings.TrimRightFunc(before, unicode.IsSpace) != before || strings.Contains(before, "$") {
+			return true
+		}
+
+		offset += pos + length
+	}
+}
+
+// matchComparisonOp returns the length of the operator op written at s[pos:],
+// or -1 if it isn't there. The words of operators like NOT EXISTS may be
+// separated by any white space, as in `NOT  EXISTS`.
+func matchComparisonOp(s string, pos int, op comparisonOperator) int {
+	end := pos + len(op)
+	if first, _, multiWord := strings.Cut(string(op), " "); multiWord {
+		if !strings.HasPrefix(s[pos:], first) {
+			return -1
+		}
+
+		end = pos
+		for i, word := range strings.Fields(string(op)) {
+			if i > 0 {
+				spaces := len(s[end:]) - len(strings.TrimLeftFunc(s[end:], unicode.IsSpace))
+				if spaces == 0 {
+					return -1
+				}
+				end += spaces
+			}
+
+			if !strings.HasPrefix(s[end:], word) {
+				return -1
+			}
+			end += len(word)
+		}
+	} else if !strings.HasPrefix(s[pos:], string(op)) {
+		return -1
+	}
+
+	if !isStandaloneOp(s, pos, end, op) {
+		return -1
+	}
+
+	return end - pos
+}
+
+// isStandaloneOp checks that word operators like IN, written at s[pos:end],
+// aren't part of a selector or value, such as `$.eventName = LINK` or
+// `$.ÄIN = a`. The neighbours are decoded as runes so non ASCII letters are
+// part of the word too.
+func isStandaloneOp(s string, pos, end int, op comparisonOperator) bool {
+	if !isWordChar(rune(op[0])) {
+		return true
+	}
+
+	if before, _ := utf8.DecodeLastRuneInString(s[:pos]); pos > 0 && isWordChar(before) {
+		return false
+	}
+
+	after, _ := utf8.DecodeRuneInString(s[end:])
+	return end >= len(s) || !isWordChar(after)
+}
+
+// isWordChar tells if r can be part of a selector or an unquoted value, such
+// as `$.x-ray.trace_id`
+func isWordChar(r rune) bool {
+	return r == '_' || r == '.' || r == '$' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
-func hasSuffixLogicalOp(s string) (bool, logicalOperator) {
+func hasPrefixLogicalOp(s string) (bool, logicalOperator) {
 	for _, op := range listLogicalOperators() {
-		if strings.HasSuffix(s, string(op)) {
+		if strings.HasPrefix(s, string(op)) {
 			return true, op
 		}
 	}
@@ -1,15 +1,21 @@
 package cloudwatch_lep
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestParse(t *testing.T) {
 	cases := map[string]struct {
 		in  string
-		out expression
+		out Expression
 		err error
 	}{
 		"simple expression": {
@@ -52,6 +58,34 @@ func TestParse(t *testing.T) {
 			in:  "{   (   $.eventName  =   DeleteGroupPolicy ))   }",
 			err: errors.New("broken parenthesis"),
 		},
+		"error on missing left operand": {
+			in:  "= b",
+			err: ErrEmptyLeftOperand,
+		},
+		"error on missing left operand of not equal": {
+			in:  "!= b",
+			err: ErrEmptyLeftOperand,
+		},
+		"error on missing left operand after spaces": {
+			in:  "   = b",
+			err: ErrEmptyLeftOperand,
+		},
+		"error on missing left operand in braces": {
+			in:  "{ (= b) }",
+			err: SubExpressionError{Expr: "= b", Span: Span{StartByte: 3, EndByte: 6}, Err: ErrEmptyLeftOperand},
+		},
+		"error on missing left operand in a group": {
+			in:  "{ $.a = b && != c }",
+			err: ErrEmptyLeftOperand,
+		},
+		"error on missing left operand of NOT EXISTS": {
+			in:  "{ NOT EXISTS }",
+			err: ErrEmptyLeftOperand,
+		},
+		"error on missing left operand of IN": {
+			in:  "{ IN [a, b] }",
+			err: ErrEmptyLeftOperand,
+		},
 		"error on double operators (double equals)": {
 			in:  "{   $.eventName == a }",
 			err: errors.New("got multiple comparison operators"),
@@ -116,6 +150,211 @@ func TestParse(t *testing.T) {
 				se("$.eventSource", coEqual, "kms.amazonaws.com"),
 			),
 		},
+		"sub expression followed by bare expression": {
+			in: "{($.a=b)&&$.c=d}",
+			out: ce("&&",
+				se("$.a", coEqual, "b"),
+				se("$.c", coEqual, "d"),
+			),
+		},
+		"bare expression followed by sub expression": {
+			in: "{$.a=b||($.c=d)}",
+			out: ce("||",
+				se("$.a", coEqual, "b"),
+				se("$.c", coEqual, "d"),
+			),
+		},
+		"sub expressions and bare expressions mixed": {
+			in: "{ ($.a=b) && $.c=d && ($.e=f)&&$.g!=h }",
+			out: ce("&&",
+				se("$.a", coEqual, "b"),
+				se("$.c", coEqual, "d"),
+				se("$.e", coEqual, "f"),
+				se("$.g", coNotEqual, "h"),
+			),
+		},
+		"nested sub expression followed by bare expression": {
+			in: "{(($.a=b)||($.c=d))&&$.e=f}",
+			out: ce("&&",
+				ce("||",
+					se("$.a", coEqual, "b"),
+					se("$.c", coEqual, "d"),
+				),
+				se("$.e", coEqual, "f"),
+			),
+		},
+		"error on sub expressions without logical operator": {
+			in:  "{($.a=b)($.c=d)}",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"error on bare expression after sub expression without logical operator": {
+			in:  "{($.a=b) $.c=d}",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"error on bare expression after sub expression without logical operator followed by more": {
+			in:  "{($.a=b) $.c=d && $.e=f}",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"error on sub expression after bare expression without logical operator": {
+			in:  "{$.c=d ($.a=b)}",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"quoted value with parenthesis": {
+			in:  "{ $.msg != \"error (code 5)\" }",
+			out: se("$.msg", coNotEqual, "\"error (code 5)\""),
+		},
+		"quoted value with unbalanced parenthesis": {
+			in:  "{ ($.msg != \"error (code 5\") }",
+			out: se("$.msg", coNotEqual, "\"error (code 5\""),
+		},
+		"quoted value with parenthesis and logical operators": {
+			in: "{ ($.msg != \"(a) && (b || c))\") || ($.level != \"||\") }",
+			out: ce("||",
+				se("$.msg", coNotEqual, "\"(a) && (b || c))\""),
+				se("$.level", coNotEqual, "\"||\""),
+			),
+		},
+		"quoted value with escaped quotes and parenthesis": {
+			in:  "{ $.msg != \"say \\\"(hi\\\" && bye\" }",
+			out: se("$.msg", coNotEqual, "\"say \\\"(hi\\\" && bye\""),
+		},
+		"error on unterminated quoted string": {
+			in:  "{ $.a = \"b) }",
+			err: errors.New("unterminated quoted string"),
+		},
+		"error on unquoted brace inside expression": {
+			in:  "{ ($.a = }) }",
+			err: errors.New("unbalanced braces"),
+		},
+		"error on balanced braces inside expression": {
+			in:  "{ ($.a = {b}) }",
+			err: errors.New("unexpected brace inside expression"),
+		},
+		"error on double braces": {
+			in:  "{{$.a=b}}",
+			err: errors.New("multiple braces around expression"),
+		},
+		"error on double braces with spaces": {
+			in:  " {  { $.a = b }  } ",
+			err: errors.New("multiple braces around expression"),
+		},
+		"error on extra closing brace": {
+			in:  "{$.a=b}}",
+			err: errors.New("unbalanced braces"),
+		},
+		"error on extra opening brace": {
+			in:  "{{$.a=b}",
+			err: errors.New("unbalanced braces"),
+		},
+		"error on missing closing brace": {
+			in:  "{$.a=b",
+			err: errors.New("unbalanced braces"),
+		},
+		"comparison operators inside quoted value": {
+			in:  "{ $.msg = \"a=b != c\" && $.level IN [\"IN\", \"<=\"] }",
+			out: ce("&&", se("$.msg", coEqual, "\"a=b != c\""), sin("$.level", "\"IN\"", "\"<=\"")),
+		},
+		"error on operator only inside quotes": {
+			in:  "{\"=\"}",
+			err: errors.New("could not find a operator for this expression"),
+		},
+		"error on term pattern": {
+			in:  "ERROR -INFO",
+			err: ErrNotJSONPattern,
+		},
+		"braces inside quoted value": {
+			in:  "{ $.a = \"{{b}\" }",
+			out: se("$.a", coEqual, "\"{{b}\""),
+		},
+		"expression without braces": {
+			in:  "$.a = b",
+			out: se("$.a", coEqual, "b"),
+		},
+		"error on value after not exists": {
+			in:  "{ $.a NOT EXISTS b }",
+			err: errors.New("unexpected value after NOT EXISTS"),
+		},
+		"error on empty expression": {
+			in:  "{ }",
+			err: errors.New("empty expression"),
+		},
+		"error on logical operator without expressions": {
+			in:  "{ && }",
+			err: errors.New("missing expression around logical operator"),
+		},
+		"in operator": {
+			in:  "{ $.eventName IN [\"CreateTrail\", UpdateTrail,DeleteTrail] }",
+			out: sin("$.eventName", "\"CreateTrail\"", "UpdateTrail", "DeleteTrail"),
+		},
+		"in operator without spaces": {
+			in:  "{ ($.eventName IN[a,b]) }",
+			out: sin("$.eventName", "a", "b"),
+		},
+		"in operator with quoted commas": {
+			in:  "{ $.msg IN [\"a, b\", \"[c]\"] }",
+			out: sin("$.msg", "\"a, b\"", "\"[c]\""),
+		},
+		"in operator inside complex expression": {
+			in: "{ $.eventSource = kms.amazonaws.com && $.eventName IN [DisableKey, ScheduleKeyDeletion] }",
+			out: ce("&&",
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+				sin("$.eventName", "DisableKey", "ScheduleKeyDeletion"),
+			),
+		},
+		"in as part of selector and value": {
+			in:  "{ $.INdex = LINK }",
+			out: se("$.INdex", coEqual, "LINK"),
+		},
+		"parenthesized value": {
+			in:  "{ $.eventName = (ConsoleLogin) }",
+			out: se("$.eventName", coEqual, "ConsoleLogin"),
+		},
+		"parenthesized values in complex expression": {
+			in: "{ ($.eventName = ( \"Console Login\" )) && $.bytes > ((10)) }",
+			out: ce("&&",
+				se("$.eventName", coEqual, "\"Console Login\""),
+				se("$.bytes", coGreaterThan, "10"),
+			),
+		},
+		"parenthesized value on the left": {
+			in: "{ (ConsoleLogin) = $.eventName && $.a = b }",
+			out: ce("&&",
+				se("ConsoleLogin", coEqual, "$.eventName"),
+				se("$.a", coEqual, "b"),
+			),
+		},
+		"parenthesized list": {
+			in:  "{ $.eventName IN ([a, b]) }",
+			out: sin("$.eventName", "a", "b"),
+		},
+		"error on parenthesized group as value": {
+			in:  "{ $.eventName = ($.a = b) }",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"error on parenthesized logical operator as value": {
+			in:  "{ $.eventName = (a || b) }",
+			err: errors.New("missing logical operator between expressions"),
+		},
+		"not in operator": {
+			in:  "{ $.eventName NOT IN [CreateTrail, \"UpdateTrail\"] }",
+			out: snotin("$.eventName", "CreateTrail", "\"UpdateTrail\""),
+		},
+		"error on not in without list": {
+			in:  "{ $.eventName NOT IN a }",
+			err: errors.New("expected a list of values like [a, b]"),
+		},
+		"error on in without list": {
+			in:  "{ $.eventName IN a }",
+			err: errors.New("expected a list of values like [a, b]"),
+		},
+		"error on in with empty list": {
+			in:  "{ $.eventName IN [] }",
+			err: errors.New("empty value in list"),
+		},
+		"error on in with empty value": {
+			in:  "{ $.eventName IN [a,,b] }",
+			err: errors.New("empty value in list"),
+		},
 		"error on complex expression alternating logical operators": {
 			in:  "{($.eventSource = kms.amazonaws.com) && ($.eventName=DisableKey) || ($.eventName=ScheduleKeyDeletion)}",
 			err: errors.New("not supported comparison with alternating logical operators"),
@@ -137,6 +376,30 @@ func TestParse(t *testing.T) {
 				),
 			),
 		},
+		"redundant parenthesis around a sub expression": {
+			in:  "{($.a=b) && (($.c=d))}",
+			out: ce("&&", se("$.a", coEqual, "b"), se("$.c", coEqual, "d")),
+		},
+		"redundant parenthesis around a complex sub expression": {
+			in:  "{ $.a=b && (( ($.c=d) || ($.e=f) )) }",
+			out: ce("&&", se("$.a", coEqual, "b"), ce("||", se("$.c", coEqual, "d"), se("$.e", coEqual, "f"))),
+		},
+		"redundant parenthesis don't count towards depth": {
+			in: "{((a=b) && ((c=d) || ((((e=f) && (g!=h || (i=j)))))))}",
+			out: ce("&&",
+				se("a", coEqual, "b"),
+				ce("||",
+					se("c", coEqual, "d"),
+					ce("&&",
+						se("e", coEqual, "f"),
+						ce("||",
+							se("g", coNotEqual, "h"),
+							se("i", coEqual, "j"),
+						),
+					),
+				),
+			),
+		},
 		"error on too deep expression": {
 			in:  "{((((((((((((a=b)&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))}",
 			err: errors.New("max depth reached, can't parse this expression"),
@@ -148,139 +411,1329 @@ func TestParse(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			s, err := parse(tc.in)
 			require.Equal(t, tc.err, err)
-			require.Equal(t, tc.out, s)
+			require.Equal(t, tc.out, withoutSpans(s))
 		})
 	}
 }
 
-func TestSimpleExpression_isEquivalent(t *testing.T) {
+func TestMustParse(t *testing.T) {
+	require.Equal(t, se("$.a", coEqual, "b"), withoutSpans(MustParse("{ $.a = b }")))
+	require.PanicsWithValue(t, `cloudwatch_lep: Parse("{ ($.a = b }"): broken parenthesis`, func() { MustParse("{ ($.a = b }") })
+}
+
+// filters known to be valid, parsed once when the package is initialized
+var (
+	rootAccountUsage = MustParse(`{ $.userIdentity.type = "Root" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != "AwsServiceEvent" }`)
+	kmsKeyDeletion   = MustParse("{($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }")
+)
+
+func ExampleMustParse() {
+	candidate, err := Parse("{ ($.eventName = ScheduleKeyDeletion || $.eventName = DisableKey) && $.eventSource = kms.amazonaws.com }")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(kmsKeyDeletion.Equals(candidate))
+	fmt.Println(rootAccountUsage.Equals(candidate))
+	// Output:
+	// true
+	// false
+}
+
+func TestIsJSONPattern(t *testing.T) {
 	cases := map[string]struct {
-		a   expression
-		b   expression
+		in  string
 		out bool
 	}{
-		"same expression": {
-			a:   se("a", coEqual, "b"),
-			b:   se("a", coEqual, "b"),
-			out: true,
-		},
-		"invert expression": {
-			a:   se("a", coEqual, "b"),
-			b:   se("b", coEqual, "a"),
-			out: true,
-		},
-		"special chars": {
-			a:   se("\"!@#$%ˆ&*()\"", coEqual, "\">P{?}|     }}{|\""),
-			b:   se("\">P{?}|     }}{|\"", coEqual, "\"!@#$%ˆ&*()\""),
-			out: true,
-		},
-		"operator not exists": {
-			a:   se("a", coNotExists, "b"),
-			b:   se("b", coNotExists, "a"),
-			out: true,
-		},
-		"operator different": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("b", coNotEqual, "a"),
-			out: true,
+		"filter":                      {in: "{ $.eventName = ConsoleLogin }", out: true},
+		"filter with spaces":          {in: "  {$.a=b}  ", out: true},
+		"clause without braces":       {in: "$.eventName = ConsoleLogin", out: true},
+		"not exists without braces":   {in: "$.a NOT EXISTS", out: true},
+		"array selector":              {in: "$[0] IN [a, b]", out: true},
+		"comparison without selector": {in: "a = b && c != d", out: true},
+		"terms":                       {in: "ERROR -INFO", out: false},
+		"optional terms":              {in: "?ERROR ?WARN", out: false},
+		"quoted terms":                {in: "\"ERROR\" \"status=500\" \"$.a\"", out: false},
+		"space-delimited fields":      {in: "[ip, user, status_code=4*, size]", out: false},
+		"empty":                       {in: "", out: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.out, IsJSONPattern(tc.in))
+		})
+	}
+}
+
+func TestParse_empty(t *testing.T) {
+	for _, in := range []string{"", " ", "\t\n", "{}", "{  }", " { } "} {
+		exp, err := Parse(in)
+		require.Equal(t, ErrEmptyExpression, err, "%q", in)
+		require.Nil(t, exp)
+	}
+
+	_, err := Parse("{ } # comment", WithStripTrailingComment())
+	require.Equal(t, ErrEmptyExpression, err)
+
+	_, err = Parse("{ () && $.a = b }")
+	require.ErrorIs(t, err, ErrEmptyExpression)
+}
+
+func TestParse_termPattern(t *testing.T) {
+	for _, in := range []string{"ERROR -INFO", "?ERROR ?WARN", "[ip, user, status_code=4*]"} {
+		_, err := Parse(in)
+		require.ErrorIs(t, err, ErrNotJSONPattern, in)
+	}
+}
+
+func TestParseSimpleStatement_spacing(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"no spaces":                   {in: "$.a=b", out: se("$.a", coEqual, "b")},
+		"spaces before operator":      {in: "$.a   =b", out: se("$.a", coEqual, "b")},
+		"spaces after operator":       {in: "$.a=   b", out: se("$.a", coEqual, "b")},
+		"spaces around operator":      {in: "$.a  =  b", out: se("$.a", coEqual, "b")},
+		"tabs around operator":        {in: "\t$.a\t=\tb\t", out: se("$.a", coEqual, "b")},
+		"different no spaces":         {in: "$.a!=b", out: se("$.a", coNotEqual, "b")},
+		"different spaces":            {in: "  $.a   !=   b  ", out: se("$.a", coNotEqual, "b")},
+		"not exists single space":     {in: "$.a NOT EXISTS", out: se("$.a", coNotExists, "")},
+		"not exists spaces":           {in: "   $.a     NOT EXISTS   ", out: se("$.a", coNotExists, "")},
+		"bang in selector":            {in: "$.a! = b", out: se("$.a!", coEqual, "b")},
+		"long selector":               {in: "$.userIdentity.sessionContext.attributes.mfaAuthenticated = true", out: se("$.userIdentity.sessionContext.attributes.mfaAuthenticated", coEqual, "true")},
+		"parenthesis and spaces":      {in: "(  $.a  =  b  )", out: se("$.a", coEqual, "b")},
+		"quoted value with spaces":    {in: "$.a  =  \"  b  \"", out: se("$.a", coEqual, "\"  b  \"")},
+		"quoted different no spaces":  {in: "$.a!=\"b\"", out: se("$.a", coNotEqual, "\"b\"")},
+		"quoted leading spaces":       {in: "$.a = \"   b\"", out: se("$.a", coEqual, "\"   b\"")},
+		"quoted tabs and parenthesis": {in: "(\t$.a =\t\"\t b \"\t)", out: se("$.a", coEqual, "\"\t b \"")},
+		"quoted only spaces":          {in: "$.a = \"  \"  ", out: se("$.a", coEqual, "\"  \"")},
+		"quoted value on the left":    {in: "  \"  b\"  =  $.a", out: se("\"  b\"", coEqual, "$.a")},
+		"quoted list values":          {in: "$.a IN [  \"  b \"  ,  c  ]", out: sin("$.a", "\"  b \"", "c")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := parseSimpleStatement(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(s))
+		})
+	}
+}
+
+func TestParse_spacingAroundOperands(t *testing.T) {
+	clauses := map[comparisonOperator]Expression{
+		coEqual:              se("$.eventSource", coEqual, "kms.amazonaws.com"),
+		coNotEqual:           se("$.eventSource", coNotEqual, "\"kms.amazonaws.com\""),
+		coLessThan:           se("$.bytes", coLessThan, "1024"),
+		coLessThanOrEqual:    se("$.bytes", coLessThanOrEqual, "1024"),
+		coGreaterThan:        se("$.bytes", coGreaterThan, "1024"),
+		coGreaterThanOrEqual: se("$.bytes", coGreaterThanOrEqual, "1024"),
+		coNotExists:          se("$.eventSource", coNotExists, ""),
+		coIn:                 sin("$.eventName", "a", "\"b\""),
+		coNotIn:              snotin("$.eventName", "a", "\"b\""),
+		coRegexMatch:         se("$.userAgent", coRegexMatch, "\"^aws-cli/.*\""),
+	}
+	pads := map[string]string{
+		"spaces":      "   ",
+		"tab":         "\t",
+		"newline":     "\n",
+		"mixed":       " \t\r\n ",
+		"unicode nbs": "\u00a0",
+	}
+
+	for _, op := range listComparisonOperator() {
+		clause := clauses[op]
+		require.NotNil(t, clause, "missing clause for %s", op)
+
+		for name, pad := range pads {
+			// pad the clause in every position: around the braces, the
+			// parenthesis, the operands and the operator
+			in := pad + "{" + pad + "(" + pad + strings.ReplaceAll(clause.String(), " ", pad) + pad + ")" + pad + "}" + pad
+			t.Run(string(op)+" "+name, func(t *testing.T) {
+				exp, err := parse(in)
+				require.NoError(t, err)
+				require.Equal(t, clause, withoutSpans(exp))
+
+				equivalent, err := areCloudWatchExpressionsEquivalent(in, "{"+clause.String()+"}")
+				require.NoError(t, err)
+				require.True(t, equivalent)
+			})
+		}
+	}
+}
+
+func TestParse_multiLine(t *testing.T) {
+	kms := ce("&&",
+		se("$.eventSource", coEqual, "kms.amazonaws.com"),
+		ce("||",
+			se("$.eventName", coEqual, "DisableKey"),
+			se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+		),
+	)
+
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"tab indented": {
+			in:  "\t\t\t{\t($.eventSource = kms.amazonaws.com)\t&&\t(($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion))\t}\t",
+			out: kms,
 		},
-		"operator doesn't match": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("b", coEqual, "a"),
-			out: false,
+		"newlines between clauses": {
+			in:  "{\n  ($.eventSource = kms.amazonaws.com) &&\n  (\n    ($.eventName = DisableKey) ||\n    ($.eventName = ScheduleKeyDeletion)\n  )\n}\n",
+			out: kms,
 		},
-		"values doesn't match on right": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("a", coNotEqual, "DIFF"),
-			out: false,
+		"CRLF line endings": {
+			in:  "{\r\n  ($.eventSource = kms.amazonaws.com)\r\n  && (($.eventName = DisableKey)\r\n  || ($.eventName = ScheduleKeyDeletion))\r\n}\r\n",
+			out: kms,
 		},
-		"values doesn't match on left": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("DIFF", coNotEqual, "b"),
-			out: false,
+		"newlines inside clauses": {
+			in:  "{ $.eventSource\n=\r\nkms.amazonaws.com && (\t$.eventName\t=\tDisableKey\n||\n$.eventName =\n\tScheduleKeyDeletion ) }",
+			out: kms,
 		},
-		"values doesn't match on right inverted": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("DIFF", coNotEqual, "a"),
-			out: false,
+		"newlines in operators and lists": {
+			in: "{ $.userIdentity.invokedBy NOT\r\n\tEXISTS &&\n$.eventName IN [\n\tDisableKey,\r\n\tScheduleKeyDeletion\n] }",
+			out: ce("&&",
+				se("$.userIdentity.invokedBy", coNotExists, ""),
+				sin("$.eventName", "DisableKey", "ScheduleKeyDeletion"),
+			),
 		},
-		"values doesn't match on both": {
-			a:   se("a", coNotEqual, "b"),
-			b:   se("DIFF", coNotEqual, "DIFF2"),
-			out: false,
+		"newlines inside quoted values are kept": {
+			in:  "{ $.msg = \"a\r\nb\" }",
+			out: se("$.msg", coEqual, "\"a\r\nb\""),
 		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			require.Equal(t, tc.out, tc.a.isEquivalent(tc.b))
-			require.Equal(t, tc.out, tc.b.isEquivalent(tc.a))
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
 		})
 	}
 }
 
-func TestComplexExpression_isEquivalent(t *testing.T) {
+func TestParse_unicode(t *testing.T) {
 	cases := map[string]struct {
-		a   expression
-		b   expression
-		out bool
+		in  string
+		out Expression
 	}{
-		"same expression": {
-			a: ce("&&",
-				se("$.userIdentity.type", coEqual, "\"Root\""),
-				se("$.userIdentity.invokedBy", coNotExists, ""),
-				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
-			),
-			b: ce("&&",
-				se("$.userIdentity.type", coEqual, "\"Root\""),
-				se("$.userIdentity.invokedBy", coNotExists, ""),
-				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
-			),
-			out: true,
-		},
-		"different order": {
-			a: ce("&&",
-				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
-				se("\"Root\"", coEqual, "$.userIdentity.type"),
-				se("$.userIdentity.invokedBy", coNotExists, ""),
-			),
+		"selector":                           {in: "{ $.ação = criar }", out: se("$.ação", coEqual, "criar")},
+		"quoted value":                       {in: "{ $.msg = \"日本語 ログ\" }", out: se("$.msg", coEqual, "\"日本語 ログ\"")},
+		"value ending in operator lookalike": {in: "{ $.a = \"x≠\" && $.b != ＝ }", out: ce("&&", se("$.a", coEqual, "\"x≠\""), se("$.b", coNotEqual, "＝"))},
+		"non ascii letter before IN":         {in: "{ $.ÄIN = a }", out: se("$.ÄIN", coEqual, "a")},
+		"non ascii letter after IN":          {in: "{ $.INé != a }", out: se("$.INé", coNotEqual, "a")},
+		"IN with unicode values":             {in: "{ $.país IN [\"España\", Ελλάδα] }", out: sin("$.país", "\"España\"", "Ελλάδα")},
+		"emoji in quoted value":              {in: "{ ($.msg = \"🔥 (fire)\") || $.msg = \"&&\" }", out: ce("||", se("$.msg", coEqual, "\"🔥 (fire)\""), se("$.msg", coEqual, "\"&&\""))},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+
+			reparsed, err := parse(exp.String())
+			require.NoError(t, err)
+			require.Equal(t, exp.String(), reparsed.String())
+		})
+	}
+}
+
+func TestParseSimpleStatement_quotedOperators(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"equal in value":            {in: "$.query = \"a=b\"", out: se("$.query", coEqual, "\"a=b\"")},
+		"query string":              {in: "$.url != \"/search?q=x&page=2\"", out: se("$.url", coNotEqual, "\"/search?q=x&page=2\"")},
+		"not equal in value":        {in: "$.expr = \"a != b\"", out: se("$.expr", coEqual, "\"a != b\"")},
+		"numeric operators":         {in: "$.expr != \"x<=1 && y>2\"", out: se("$.expr", coNotEqual, "\"x<=1 && y>2\"")},
+		"word operators":            {in: "$.msg = \"IN NOT EXISTS\"", out: se("$.msg", coEqual, "\"IN NOT EXISTS\"")},
+		"escaped quote and equal":   {in: "$.msg = \"a\\\"=b\"", out: se("$.msg", coEqual, "\"a\\\"=b\"")},
+		"quoted value on the left":  {in: "\"a=b\" = $.query", out: se("\"a=b\"", coEqual, "$.query")},
+		"list values with operator": {in: "$.q IN [\"a=b\", \"c!=d\"]", out: sin("$.q", "\"a=b\"", "\"c!=d\"")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := parseSimpleStatement(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(s))
+		})
+	}
+
+	_, err := parseSimpleStatement("$.query = \"a=b\" = c")
+	require.Equal(t, errors.New("got multiple comparison operators"), err)
+}
+
+func TestParseSimpleStatement_operatorCharsInValue(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"equal in value":              {in: "$.query = a=b", out: se("$.query", coEqual, "a=b")},
+		"value ending in equal":       {in: "$.token = YWJj=", out: se("$.token", coEqual, "YWJj=")},
+		"value ending in not equal":   {in: "$.msg != abc!=", out: se("$.msg", coNotEqual, "abc!=")},
+		"numeric operator in value":   {in: "$.expr = x>=1", out: se("$.expr", coEqual, "x>=1")},
+		"several operators in value":  {in: "$.url = /q?a=1&b=2", out: se("$.url", coEqual, "/q?a=1&b=2")},
+		"selector ending in !":        {in: "$.a! = b", out: se("$.a!", coEqual, "b")},
+		"selector ending in ! packed": {in: "$.wow!=b", out: se("$.wow", coNotEqual, "b")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := parseSimpleStatement(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(s))
+
+			again, err := parse(s.String())
+			require.NoError(t, err)
+			require.True(t, s.StructurallyEqual(again))
+		})
+	}
+
+	for _, in := range []string{"$.a = =b", "$.a = b =", "$.a = b = c", "$.a = b&$.c=d"} {
+		_, err := parseSimpleStatement(in)
+		require.Equal(t, errors.New("got multiple comparison operators"), err, in)
+	}
+}
+
+func TestParse_keywordsInSelectors(t *testing.T) {
+	// operator keywords inside a selector aren't operators
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"NOT inside the selector":        {in: "{ $.isNOTallowed NOT EXISTS }", out: se("$.isNOTallowed", coNotExists, "")},
+		"selector ending in NOT":         {in: "{ $.allowedNOT NOT EXISTS }", out: se("$.allowedNOT", coNotExists, "")},
+		"selector key NOT":               {in: "{ $.a.NOT NOT EXISTS }", out: se("$.a.NOT", coNotExists, "")},
+		"selector key NOT before IN":     {in: "{ $.NOT IN [a, b] }", out: sin("$.NOT", "a", "b")},
+		"selector key NOT_EXISTS":        {in: "{ $.NOT_EXISTS NOT EXISTS }", out: se("$.NOT_EXISTS", coNotExists, "")},
+		"selector ending in IN":          {in: "{ $.loggedIN IN [yes] }", out: sin("$.loggedIN", "yes")},
+		"selector ending in NOT IN":      {in: "{ $.isNOTIN NOT IN [a] }", out: snotin("$.isNOTIN", "a")},
+		"selector ending in EXISTS":      {in: "{ $.keyEXISTS != NOT }", out: se("$.keyEXISTS", coNotEqual, "NOT")},
+		"keywords in selector and value": {in: "{ $.isNOTallowed = NOTEXISTS }", out: se("$.isNOTallowed", coEqual, "NOTEXISTS")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+		})
+	}
+
+	// NOT glued to the selector doesn't make a NOT EXISTS operator
+	for _, in := range []string{"{ $.allowedNOT EXISTS }", "{ $.a-NOT EXISTS }"} {
+		_, err := parse(in)
+		require.Equal(t, errors.New("could not find a operator for this expression"), err, in)
+	}
+}
+
+func TestParse_hyphenatedSelectors(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"hyphenated selector":         {in: "{ $.x-ray.trace-id = abc-123 }", out: se("$.x-ray.trace-id", coEqual, "abc-123")},
+		"hyphen before IN":            {in: "{ $.x-IN = a }", out: se("$.x-IN", coEqual, "a")},
+		"hyphen after IN":             {in: "{ $.IN-x != a }", out: se("$.IN-x", coNotEqual, "a")},
+		"IN with hyphenated selector": {in: "{ $.trace-id IN [a-1, b-2] }", out: sin("$.trace-id", "a-1", "b-2")},
+		"array index and hyphens":     {in: "{ $.resources[0].aws-region NOT EXISTS }", out: se("$.resources[0].aws-region", coNotExists, "")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := Parse(tc.in, WithStrictSelectors(), WithNormalizeQuotes())
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+		})
+	}
+
+	exp, err := parse("{ a = $.x-ray.id && $.b-c > -1 }")
+	require.NoError(t, err)
+	require.Equal(t, ce("&&", se("a", coEqual, "$.x-ray.id"), se("$.b-c", coGreaterThan, "-1")), withoutSpans(exp))
+
+	a, err := parse("{ $.x-ray.trace-id = \"abc\" && $.x-ray.span-id != b }")
+	require.NoError(t, err)
+	b, err := parse("{ b != $.x-ray.span-id && \"abc\" = $.x-ray.trace-id }")
+	require.NoError(t, err)
+	require.True(t, a.Equals(b))
+	require.Equal(t, Hash(a), Hash(b))
+
+	c, err := parse("{ $.x-ray.trace-id = \"abc\" && $.x-ray.span = b }")
+	require.NoError(t, err)
+	require.False(t, a.Equals(c))
+	require.NoError(t, ValidateCloudWatchFilter("{ $.x-ray.trace-id = abc-123 }"))
+}
+
+func TestListComparisonOperator(t *testing.T) {
+	operators := listComparisonOperator()
+	for i := 1; i < len(operators); i++ {
+		require.GreaterOrEqual(t, len(operators[i-1]), len(operators[i]), "%q must be matched before %q", operators[i-1], operators[i])
+	}
+
+	// every operator that is a prefix of another one must come after it
+	for i, op := range operators {
+		for _, longer := range operators[:i] {
+			require.False(t, strings.HasPrefix(string(op), string(longer)) && op != longer)
+		}
+	}
+}
+
+// registerForTest registers the comparison operator token until the test ends
+func registerForTest(t *testing.T, token string) {
+	restoreOperatorsAfter(t)
+	RegisterComparisonOperator(token)
+}
+
+// restoreOperatorsAfter restores the operators known now when the test ends
+func restoreOperatorsAfter(t *testing.T) {
+	table := loadOperators()
+	t.Cleanup(func() {
+		operators.Store(table)
+	})
+}
+
+func TestRegisterComparisonOperator(t *testing.T) {
+	registerForTest(t, "!~")
+	registerForTest(t, "CONTAINS")
+
+	operators := listComparisonOperator()
+	for i := 1; i < len(operators); i++ {
+		require.GreaterOrEqual(t, len(operators[i-1]), len(operators[i]))
+	}
+
+	exp, err := parse("{ $.msg !~ \"^a.*\" && $.tags CONTAINS prod && $.CONTAINSx = y }")
+	require.NoError(t, err)
+	require.Equal(t, ce("&&",
+		se("$.msg", "!~", "\"^a.*\""),
+		se("$.tags", "CONTAINS", "prod"),
+		se("$.CONTAINSx", coEqual, "y"),
+	), withoutSpans(exp))
+	require.Equal(t, "CONTAINS", exp.(complexExpression).expressions[1].Operator())
+
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"same clause":       {a: "{ $.a !~ b }", b: "{$.a!~b}", out: true},
+		"swapped operands":  {a: "{ $.a !~ b }", b: "{ b !~ $.a }", out: false},
+		"different value":   {a: "{ $.a CONTAINS b }", b: "{ $.a CONTAINS c }", out: false},
+		"other operator":    {a: "{ $.a CONTAINS b }", b: "{ $.a = b }", out: false},
+		"reordered clauses": {a: "{ $.a CONTAINS b && $.c !~ d }", b: "{ $.c !~ d && $.a CONTAINS b }", out: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.a, tc.b)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+
+			a, _ := parse(tc.a)
+			b, _ := parse(tc.b)
+			require.Equal(t, tc.out, Hash(a) == Hash(b))
+		})
+	}
+}
+
+func TestParse_regexMatch(t *testing.T) {
+	exp, err := parse("{ $.userAgent =~ \"^aws-cli/.*\" && $.a = ~b && $.c=~d }")
+	require.NoError(t, err)
+	require.Equal(t, ce("&&",
+		se("$.userAgent", coRegexMatch, "\"^aws-cli/.*\""),
+		se("$.a", coEqual, "~b"),
+		se("$.c", coRegexMatch, "d"),
+	), withoutSpans(exp))
+
+	// patterns are compared as written
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"same pattern":       {a: "{ $.a =~ \"^b.*\" }", b: "{ $.a=~\"^b.*\" }", out: true},
+		"equal":              {a: "{ $.a =~ b }", b: "{ $.a = b }", out: false},
+		"equal to the tilde": {a: "{ $.a =~ b }", b: "{ $.a = ~b }", out: false},
+		"equivalent pattern": {a: "{ $.a =~ \"b|c\" }", b: "{ $.a =~ \"c|b\" }", out: false},
+		"swapped operands":   {a: "{ $.a =~ b }", b: "{ b =~ $.a }", out: false},
+		"reordered clauses":  {a: "{ $.a =~ b && $.c = d }", b: "{ $.c = d && $.a =~ b }", out: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.a, tc.b)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+
+			a, _ := parse(tc.a)
+			b, _ := parse(tc.b)
+			require.Equal(t, tc.out, Hash(a) == Hash(b))
+		})
+	}
+
+	// value options don't apply to patterns
+	equivalent, err := EquivalentWithOptions("{ $.a =~ \"^B\" }", "{ $.a =~ \"^b\" }", CompareOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	require.False(t, equivalent)
+}
+
+func TestSelectorNotations(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"dot and bracket notation":       {a: "{ $.foo.bar = x }", b: "{ $[\"foo\"][\"bar\"] = x }", out: true},
+		"mixed notation":                 {a: "{ $.foo.bar = x }", b: "{ $.foo[\"bar\"] = x }", out: true},
+		"single quoted keys":             {a: "{ $.foo.bar = x }", b: "{ $['foo'].bar = x }", out: true},
+		"swapped operands":               {a: "{ $.foo.bar = x }", b: "{ x = $[\"foo\"].bar }", out: true},
+		"array index":                    {a: "{ $.resources[0].type = x }", b: "{ $[\"resources\"][0][\"type\"] = x }", out: true},
+		"IN list":                        {a: "{ $.foo.bar IN [x, y] }", b: "{ $[\"foo\"].bar = y || $.foo.bar = x }", out: true},
+		"OR set of values":               {a: "{ $.a = x || $.a = y || $.a = z }", b: "{ $[\"a\"] = z || $['a'] = y || $[\"a\"] = x }", out: true},
+		"reordered group":                {a: "{ $.a.b = x && $.c NOT EXISTS }", b: "{ $[\"c\"] NOT EXISTS && $.a[\"b\"] = x }", out: true},
+		"other key":                      {a: "{ $.foo.bar = x }", b: "{ $[\"foo\"][\"baz\"] = x }", out: false},
+		"dotted key isn't a nested path": {a: "{ $.a.b = x }", b: "{ $[\"a.b\"] = x }", out: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.a, tc.b)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+
+			a, _ := parse(tc.a)
+			b, _ := parse(tc.b)
+			require.Equal(t, tc.out, Hash(a) == Hash(b))
+		})
+	}
+}
+
+func TestAreCloudWatchExpressionsEquivalent_duplicates(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"adjacent AND duplicates":         {a: "{ $.x = a && $.x = a && $.y = b }", b: "{ $.x = a && $.y = b }", out: true},
+		"non-adjacent AND duplicates":     {a: "{ $.x = a && $.y = b && $.x = a }", b: "{ $.y = b && $.x = a }", out: true},
+		"non-adjacent OR duplicates":      {a: "{ $.x = a || $.y = b || a = $.x }", b: "{ $.y = b || $.x = a }", out: true},
+		"OR set of values":                {a: "{ $.e = A || $.e = B || $.e = C || $.e = A }", b: "{ $.e = C || $.e = B || $.e = A }", out: true},
+		"duplicates on both sides":        {a: "{ $.x = a && $.y = b && $.x = a }", b: "{ $.y = b && $.x = a && $.y = b }", out: true},
+		"duplicated nested groups":        {a: "{ ($.a = b || $.c = d) && $.e = f && ($.c = d || $.a = b) }", b: "{ $.e = f && ($.a = b || $.c = d) }", out: true},
+		"duplicates through an IN list":   {a: "{ $.x IN [a, b] || $.x = a }", b: "{ $.x = b || $.x = a }", out: true},
+		"group of a single clause":        {a: "{ $.a = b && b = $.a }", b: "{ $.a = b }", out: true},
+		"duplicates in a nested group":    {a: "{ $.a = x || ($.b = y && $.b = y) }", b: "{ $.a = x || $.b = y }", out: true},
+		"duplicated IN lists":             {a: "{ $.b IN [x, y] && $.b IN [x, y] }", b: "{ $.b IN [x, y] }", out: true},
+		"duplicated reordered IN lists":   {a: "{ $.b IN [y, x] && $.b IN [y, x] }", b: "{ $.b IN [x, y] && $.b IN [x, y] }", out: true},
+		"duplicated NOT IN lists":         {a: "{ $.b NOT IN [x, y] || $.b NOT IN [y, x] }", b: "{ $.b != x && $.b != y }", out: true},
+		"duplicates and a missing clause": {a: "{ $.x = a && $.x = a && $.y = b }", b: "{ $.x = a && $.y = b && $.z = c }", out: false},
+		"duplicates and another value":    {a: "{ $.x = a || $.x = a }", b: "{ $.x = a || $.x = b }", out: false},
+		"duplicated IN lists and a value": {a: "{ $.b IN [x, y] && $.b IN [x, y] }", b: "{ $.b IN [x, z] }", out: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, args := range [][2]string{{tc.a, tc.b}, {tc.b, tc.a}} {
+				result, err := CompareExpressions(args[0], args[1])
+				require.NoError(t, err)
+				require.Equal(t, tc.out, result == Equivalent)
+
+				fast, err := EquivalentFast(args[0], args[1])
+				require.NoError(t, err)
+				require.Equal(t, tc.out, fast)
+			}
+
+			a, _ := parse(tc.a)
+			b, _ := parse(tc.b)
+			require.Equal(t, tc.out, Hash(a) == Hash(b))
+		})
+	}
+
+	equivalent, err := EquivalentWithOptions("{ $.a = B && $.c = d && $.a = b }", "{ $.a = b && $.c = d }", CompareOptions{CaseInsensitiveValues: true})
+	require.NoError(t, err)
+	require.True(t, equivalent)
+
+	// comparing by position keeps the duplicates
+	equivalent, err = EquivalentWithOptions("{ $.x = a && $.x = a && $.y = b }", "{ $.x = a && $.y = b }", CompareOptions{StrictOrder: true})
+	require.NoError(t, err)
+	require.False(t, equivalent)
+}
+
+func TestParse_multiWordOperatorSpacing(t *testing.T) {
+	registerForTest(t, "IS  TRUE")
+
+	cases := map[string]struct {
+		in  string
+		out Expression
+		err error
+	}{
+		"not exists":                  {in: "{ $.a NOT EXISTS }", out: se("$.a", coNotExists, "")},
+		"not exists with two spaces":  {in: "{ $.a NOT  EXISTS }", out: se("$.a", coNotExists, "")},
+		"not exists with tab":         {in: "{ $.a NOT\tEXISTS }", out: se("$.a", coNotExists, "")},
+		"not exists in a group":       {in: "{ $.a NOT   EXISTS && $.b = c }", out: ce("&&", se("$.a", coNotExists, ""), se("$.b", coEqual, "c"))},
+		"registered with two spaces":  {in: "{ $.a IS  TRUE }", out: se("$.a", "IS TRUE", "")},
+		"registered with one space":   {in: "{ $.a IS TRUE }", out: se("$.a", "IS TRUE", "")},
+		"error on joined words":       {in: "{ $.a NOTEXISTS }", err: errors.New("could not find a operator for this expression")},
+		"error on value after spaced": {in: "{ $.a NOT  EXISTS b }", err: errors.New("unexpected value after NOT EXISTS")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+		})
+	}
+
+	equivalent, err := areCloudWatchExpressionsEquivalent("{ $.a NOT  EXISTS }", "{ $.a NOT EXISTS }")
+	require.NoError(t, err)
+	require.True(t, equivalent)
+
+	exp, err := parse("{ $.a NOT \t EXISTS }")
+	require.NoError(t, err)
+	require.Equal(t, "$.a NOT EXISTS", exp.String())
+	require.Equal(t, "NOT EXISTS", exp.Operator())
+}
+
+func TestParse_cisFiltersRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/cis-filters.txt")
+	require.NoError(t, err)
+
+	count := 0
+	for i, line := range strings.Split(string(data), "\n") {
+		filter := strings.TrimSpace(line)
+		if filter == "" || strings.HasPrefix(filter, "#") {
+			continue
+		}
+		count++
+
+		t.Run("line "+strconv.Itoa(i+1), func(t *testing.T) {
+			exp, err := parse(filter)
+			require.NoError(t, err, "parsing %s", filter)
+
+			rendered := exp.String()
+			again, err := parse(rendered)
+			require.NoError(t, err, "parsing %s rendered as %s", filter, rendered)
+			require.True(t, exp.Equals(again), "%s rendered as the different %s", filter, rendered)
+			require.True(t, exp.StructurallyEqual(again), "%s rendered as %s, parsed differently", filter, rendered)
+			require.Equal(t, rendered, again.String())
+		})
+	}
+
+	require.GreaterOrEqual(t, count, 30)
+}
+
+func TestParse_quotedUserAgent(t *testing.T) {
+	// operators, parenthesis and braces inside quotes are part of the value
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"logical operator and parenthesis": {
+			in:  `{ $.userAgent = "Mozilla/5.0 (X11; Linux) && fake" }`,
+			out: se("$.userAgent", coEqual, `"Mozilla/5.0 (X11; Linux) && fake"`),
+		},
+		"comparison operators": {
+			in:  `{ ($.userAgent = "a (b || c) = d != e >= f NOT EXISTS IN [g]") && $.x = "}{" }`,
+			out: ce("&&", se("$.userAgent", coEqual, `"a (b || c) = d != e >= f NOT EXISTS IN [g]"`), se("$.x", coEqual, `"}{"`)),
+		},
+		"browser and escaped quotes": {
+			in: `{ $.userAgent != "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36" || ($.userAgent = "curl/8.0 && (\"x\")") }`,
+			out: ce("||",
+				se("$.userAgent", coNotEqual, `"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"`),
+				se("$.userAgent", coEqual, `"curl/8.0 && (\"x\")"`),
+			),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+
+			again, err := parse(exp.String())
+			require.NoError(t, err)
+			require.True(t, exp.StructurallyEqual(again))
+		})
+	}
+}
+
+func TestParse_quotedBraces(t *testing.T) {
+	// only the braces outside quotes wrap the filter
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"JSON value without outer braces": {
+			in:  `$.json = "{\"k\":1}"`,
+			out: se("$.json", coEqual, `"{\"k\":1}"`),
+		},
+		"JSON value with outer braces": {
+			in:  `{ $.json = "{\"k\":1}" }`,
+			out: se("$.json", coEqual, `"{\"k\":1}"`),
+		},
+		"value ending in a brace without outer braces": {
+			in:  `$.a = "{" && $.b = "}"`,
+			out: ce("&&", se("$.a", coEqual, `"{"`), se("$.b", coEqual, `"}"`)),
+		},
+		"value first starting with a brace": {
+			in:  `"{\"k\":[1]}" = $.json || $.b = c`,
+			out: ce("||", se(`"{\"k\":[1]}"`, coEqual, "$.json"), se("$.b", coEqual, "c")),
+		},
+		"closing brace inside the last value": {
+			in:  `{$.a = "}"}`,
+			out: se("$.a", coEqual, `"}"`),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+		})
+	}
+
+	_, err := parse(`{ { $.json = "{}" } }`)
+	require.Equal(t, errors.New("multiple braces around expression"), err)
+}
+
+func TestParse_danglingOperator(t *testing.T) {
+	cases := map[string]struct {
+		in       string
+		operator LogicalOperator
+		written  string // the text of the filter before the operator
+	}{
+		"trailing AND":                   {in: "$.a=b &&", operator: And, written: "$.a=b "},
+		"trailing OR in braces":          {in: "{$.a=b ||}", operator: Or, written: "{$.a=b "},
+		"white space after the operator": {in: "{ $.a = b && $.c = d &&  \t\n }", operator: And, written: "{ $.a = b && $.c = d "},
+		"after a sub expression":         {in: "{ ($.a = b) || }", operator: Or, written: "{ ($.a = b) "},
+		"in a sub expression":            {in: "{ $.a = b && ($.c = d ||  ) }", operator: Or, written: "{ $.a = b && ($.c = d "},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parse(tc.in)
+			require.ErrorIs(t, err, ErrDanglingOperator)
+
+			var dangling DanglingOperatorError
+			require.True(t, errors.As(err, &dangling))
+			require.Equal(t, tc.operator, dangling.Operator)
+			require.Equal(t, tc.written, tc.in[:dangling.Span.StartByte])
+			require.Equal(t, string(tc.operator), tc.in[dangling.Span.StartByte:dangling.Span.EndByte])
+		})
+	}
+
+	_, err := parse("{ $.a = b && }")
+	require.EqualError(t, err, "dangling logical operator && at byte 10")
+}
+
+func TestParse_tightLogicalOperators(t *testing.T) {
+	// && and || always join clauses outside quotes, even right after a value
+	cases := map[string]struct {
+		in     string
+		spaced string
+		out    Expression
+	}{
+		"AND":              {in: "$.a=b&&$.c=d", spaced: "{ $.a = b && $.c = d }", out: ce("&&", se("$.a", coEqual, "b"), se("$.c", coEqual, "d"))},
+		"OR with braces":   {in: "{$.a=b||$.c=d}", spaced: "{ $.a = b || $.c = d }", out: ce("||", se("$.a", coEqual, "b"), se("$.c", coEqual, "d"))},
+		"bare selectors":   {in: "{a=b&&c=d}", spaced: "{ a = b && c = d }", out: ce("&&", se("a", coEqual, "b"), se("c", coEqual, "d"))},
+		"after a list":     {in: "{$.a IN [b,c]&&$.d=e}", spaced: "{ $.a IN [b, c] && $.d = e }", out: ce("&&", sin("$.a", "b", "c"), se("$.d", coEqual, "e"))},
+		"after NOT EXISTS": {in: "{$.a NOT EXISTS||$.b=c}", spaced: "{ $.a NOT EXISTS || $.b = c }", out: ce("||", se("$.a", coNotExists, ""), se("$.b", coEqual, "c"))},
+		"before a group":   {in: "{$.a=b&&($.c=d||$.e=f)}", spaced: "{ $.a = b && ($.c = d || $.e = f) }", out: ce("&&", se("$.a", coEqual, "b"), ce("||", se("$.c", coEqual, "d"), se("$.e", coEqual, "f")))},
+		"quoted operator":  {in: "{$.a=\"b&&c\"&&$.d=e}", spaced: "{ $.a = \"b&&c\" && $.d = e }", out: ce("&&", se("$.a", coEqual, "\"b&&c\""), se("$.d", coEqual, "e"))},
+		"wildcard value":   {in: "{$.a=b*&&$.c=*d}", spaced: "{ $.a = b* && $.c = *d }", out: ce("&&", se("$.a", coEqual, "b*"), se("$.c", coEqual, "*d"))},
+		"numbers":          {in: "{$.a>=10&&$.b<5}", spaced: "{ $.a >= 10 && $.b < 5 }", out: ce("&&", se("$.a", coGreaterThanOrEqual, "10"), se("$.b", coLessThan, "5"))},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(exp))
+
+			spaced, err := parse(tc.spaced)
+			require.NoError(t, err)
+			require.True(t, exp.StructurallyEqual(spaced))
+		})
+	}
+
+	_, err := parse("{$.a=b&$.c=d}")
+	require.Equal(t, errors.New("got multiple comparison operators"), err)
+}
+
+func TestParse_clauseParenthesisMatrix(t *testing.T) {
+	clauses := []string{"$.eventSource = kms.amazonaws.com", "$.eventName=DisableKey", "$.errorCode NOT EXISTS"}
+
+	for _, operator := range []string{"&&", "||"} {
+		bare := strings.Join(clauses, " "+operator+" ")
+		want, err := parse("{ " + bare + " }")
+		require.NoError(t, err)
+
+		// every combination of parenthesized clauses, from fully bare to fully
+		// parenthesized, with and without parenthesis around the group
+		for mask := 0; mask < 1<<len(clauses); mask++ {
+			wrapped := make([]string, len(clauses))
+			for i, clause := range clauses {
+				wrapped[i] = clause
+				if mask&(1<<i) != 0 {
+					wrapped[i] = "(" + clause + ")"
+				}
+			}
+
+			group := strings.Join(wrapped, " "+operator+" ")
+			for _, in := range []string{"{ " + group + " }", "{ (" + group + ") }", group} {
+				t.Run(in, func(t *testing.T) {
+					exp, err := parse(in)
+					require.NoError(t, err)
+					require.True(t, want.StructurallyEqual(exp))
+
+					result, err := CompareExpressions("{ "+bare+" }", in)
+					require.NoError(t, err)
+					require.Equal(t, Equivalent, result)
+				})
+			}
+		}
+	}
+}
+
+func TestParse_notExistsSentinel(t *testing.T) {
+	// NOT EXISTS is an operator of its own, a value spelling a sentinel for it
+	// must not be read as one
+	for _, sentinel := range []string{"__NOT_EXISTS__", "\"__NOT_EXISTS__\""} {
+		exp, err := parse("{ $.a = " + sentinel + " }")
+		require.NoError(t, err)
+		require.Equal(t, se("$.a", coEqual, sentinel), withoutSpans(exp))
+		require.Equal(t, "$.a = "+sentinel, exp.String())
+
+		equivalent, err := areCloudWatchExpressionsEquivalent("{ $.a = "+sentinel+" }", "{ $.a NOT EXISTS }")
+		require.NoError(t, err)
+		require.False(t, equivalent)
+	}
+
+	equivalent, err := areCloudWatchExpressionsEquivalent(
+		"{ $.userIdentity.invokedBy NOT EXISTS && $.userIdentity.type = \"Root\" }",
+		"{ $.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS }",
+	)
+	require.NoError(t, err)
+	require.True(t, equivalent)
+}
+
+func TestRegisterComparisonOperator_invalid(t *testing.T) {
+	for _, token := range []string{"", "  ", "=", "NOT EXISTS", "<>", "(", "\"", "a&&b", "||"} {
+		require.Panics(t, func() { RegisterComparisonOperator(token) }, token)
+	}
+}
+
+func TestParseSimpleStatement_numericOperators(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out Expression
+	}{
+		"less than":                   {in: "$.bytes < 10", out: se("$.bytes", coLessThan, "10")},
+		"less than or equal":          {in: "$.bytes <= 10", out: se("$.bytes", coLessThanOrEqual, "10")},
+		"less than or equal no space": {in: "$.bytes<=10", out: se("$.bytes", coLessThanOrEqual, "10")},
+		"greater than":                {in: "$.bytes > 10", out: se("$.bytes", coGreaterThan, "10")},
+		"greater than or equal":       {in: "$.bytes>=10", out: se("$.bytes", coGreaterThanOrEqual, "10")},
+		"negative integer":            {in: "$.delta = -5", out: se("$.delta", coEqual, "-5")},
+		"negative integer no space":   {in: "$.delta>-5", out: se("$.delta", coGreaterThan, "-5")},
+		"decimal":                     {in: "$.rate = 0.25", out: se("$.rate", coEqual, "0.25")},
+		"leading dot decimal":         {in: "$.rate < .5", out: se("$.rate", coLessThan, ".5")},
+		"negative leading dot":        {in: "$.rate >= -.5", out: se("$.rate", coGreaterThanOrEqual, "-.5")},
+		"negative value first":        {in: "-5 < $.delta", out: se("-5", coLessThan, "$.delta")},
+		"numbers in a list":           {in: "$.rate IN [-1, .5, 2.0]", out: sin("$.rate", "-1", ".5", "2.0")},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := parseSimpleStatement(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, withoutSpans(s))
+		})
+	}
+
+	_, err := parseSimpleStatement("$.bytes =< 10")
+	require.Equal(t, errors.New("got multiple comparison operators"), err)
+}
+
+func TestAreCloudWatchExpressionsEquivalent_numbers(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"leading dot decimal":        {a: "{ $.rate < .5 }", b: "{ $.rate < 0.5 }", out: true},
+		"trailing zeros":             {a: "{ $.rate >= 0.250 }", b: "{ $.rate >= .25 }", out: true},
+		"negative numbers":           {a: "{ $.delta > -5 }", b: "{ $.delta > -5.0 }", out: true},
+		"negative swapped operands":  {a: "{ $.delta > -.5 }", b: "{ -0.5 < $.delta }", out: true},
+		"exponent":                   {a: "{ $.bytes <= 1e3 }", b: "{ $.bytes <= 1000 }", out: true},
+		"other sign":                 {a: "{ $.delta > -5 }", b: "{ $.delta > 5 }", out: false},
+		"other number":               {a: "{ $.rate < .5 }", b: "{ $.rate < .25 }", out: false},
+		"quoted number":              {a: "{ $.rate < \".5\" }", b: "{ $.rate < 0.5 }", out: false},
+		"equal compares as written":  {a: "{ $.rate = .5 }", b: "{ $.rate = 0.5 }", out: false},
+		"thresholds in an AND group": {a: "{ $.rate > .1 && $.rate < .9 }", b: "{ $.rate < 0.9 && $.rate > 0.1 }", out: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.a, tc.b)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+
+			a, _ := parse(tc.a)
+			b, _ := parse(tc.b)
+			require.Equal(t, tc.out, Hash(a) == Hash(b))
+		})
+	}
+
+	stricter, err := IsStricterThan("{ $.rate > .5 }", "{ $.rate > -0.5 }")
+	require.NoError(t, err)
+	require.True(t, stricter)
+}
+
+func TestParse_operatorAliases(t *testing.T) {
+	exp, err := parse("{ $.a <> b && $.c<>\"d <> e\" }")
+	require.NoError(t, err)
+	require.Equal(t, ce("&&",
+		se("$.a", coNotEqual, "b"),
+		se("$.c", coNotEqual, "\"d <> e\""),
+	), withoutSpans(exp))
+	require.Equal(t, "$.a != b && $.c != \"d <> e\"", exp.String())
+
+	cases := map[string]struct {
+		a   string
+		b   string
+		out bool
+	}{
+		"alias and operator":          {a: "{ $.a <> b }", b: "{ $.a != b }", out: true},
+		"alias with swapped operands": {a: "{ b <> $.a }", b: "{ $.a != b }", out: true},
+		"both with swapped operands":  {a: "{ $.a <> b && c <> $.d }", b: "{ $.d != c && b != $.a }", out: true},
+		"alias and equals":            {a: "{ $.a <> b }", b: "{ $.a = b }", out: false},
+		"alias and less than":         {a: "{ $.a <> 1 }", b: "{ $.a < 1 }", out: false},
+		"alias and other value":       {a: "{ $.a <> b }", b: "{ $.a != c }", out: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			equivalent, err := areCloudWatchExpressionsEquivalent(tc.a, tc.b)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+
+			equivalent, err = areCloudWatchExpressionsEquivalent(tc.b, tc.a)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, equivalent)
+		})
+	}
+
+	_, err = parseSimpleStatement("$.a <>= b")
+	require.Equal(t, errors.New("got multiple comparison operators"), err)
+}
+
+func TestSimpleExpression_isEquivalent(t *testing.T) {
+	cases := map[string]struct {
+		a   Expression
+		b   Expression
+		out bool
+	}{
+		"same expression": {
+			a:   se("a", coEqual, "b"),
+			b:   se("a", coEqual, "b"),
+			out: true,
+		},
+		"invert expression": {
+			a:   se("a", coEqual, "b"),
+			b:   se("b", coEqual, "a"),
+			out: true,
+		},
+		"special chars": {
+			a:   se("\"!@#$%ˆ&*()\"", coEqual, "\">P{?}|     }}{|\""),
+			b:   se("\">P{?}|     }}{|\"", coEqual, "\"!@#$%ˆ&*()\""),
+			out: true,
+		},
+		"wildcard is literal": {
+			a:   se("$.errorCode", coEqual, "\"AccessDenied*\""),
+			b:   se("$.errorCode", coEqual, "\"AccessDenied*\""),
+			out: true,
+		},
+		"wildcard doesn't match other values": {
+			a:   se("$.errorCode", coEqual, "\"a*\""),
+			b:   se("$.errorCode", coEqual, "\"ab\""),
+			out: false,
+		},
+		"wildcard doesn't match empty suffix": {
+			a:   se("$.errorCode", coEqual, "a*"),
+			b:   se("$.errorCode", coEqual, "a"),
+			out: false,
+		},
+		"leading wildcard is literal": {
+			a:   se("$.errorCode", coEqual, "\"*UnauthorizedOperation\""),
+			b:   se("$.errorCode", coEqual, "\"Client.UnauthorizedOperation\""),
+			out: false,
+		},
+		"in operator": {
+			a:   sin("$.x", "a", "b"),
+			b:   sin("$.x", "a", "b"),
+			out: true,
+		},
+		"in operator different order": {
+			a:   sin("$.x", "a", "b", "c"),
+			b:   sin("$.x", "c", "a", "b"),
+			out: true,
+		},
+		"in operator different values": {
+			a:   sin("$.x", "a", "b"),
+			b:   sin("$.x", "a", "c"),
+			out: false,
+		},
+		"in operator different selectors": {
+			a:   sin("$.x", "a", "b"),
+			b:   sin("$.y", "a", "b"),
+			out: false,
+		},
+		"in operator with single value and equals": {
+			a:   sin("$.x", "a"),
+			b:   se("a", coEqual, "$.x"),
+			out: true,
+		},
+		"in operator and equals": {
+			a:   sin("$.x", "a", "b"),
+			b:   se("$.x", coEqual, "a"),
+			out: false,
+		},
+		"less than": {
+			a:   se("$.bytes", coLessThan, "10"),
+			b:   se("$.bytes", coLessThan, "10"),
+			out: true,
+		},
+		"less than swapped is greater than": {
+			a:   se("$.bytes", coLessThan, "10"),
+			b:   se("10", coGreaterThan, "$.bytes"),
+			out: true,
+		},
+		"less than or equal swapped is greater than or equal": {
+			a:   se("bytes", coLessThanOrEqual, "10"),
+			b:   se("10", coGreaterThanOrEqual, "bytes"),
+			out: true,
+		},
+		"less than swapped without mirroring": {
+			a:   se("$.bytes", coLessThan, "10"),
+			b:   se("10", coLessThan, "$.bytes"),
+			out: false,
+		},
+		"less than and less than or equal": {
+			a:   se("$.bytes", coLessThan, "10"),
+			b:   se("$.bytes", coLessThanOrEqual, "10"),
+			out: false,
+		},
+		"operator not exists": {
+			a:   se("a", coNotExists, "b"),
+			b:   se("b", coNotExists, "a"),
+			out: true,
+		},
+		"operator different": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("b", coNotEqual, "a"),
+			out: true,
+		},
+		"operator doesn't match": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("b", coEqual, "a"),
+			out: false,
+		},
+		"values doesn't match on right": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("a", coNotEqual, "DIFF"),
+			out: false,
+		},
+		"values doesn't match on left": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("DIFF", coNotEqual, "b"),
+			out: false,
+		},
+		"values doesn't match on right inverted": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("DIFF", coNotEqual, "a"),
+			out: false,
+		},
+		"values doesn't match on both": {
+			a:   se("a", coNotEqual, "b"),
+			b:   se("DIFF", coNotEqual, "DIFF2"),
+			out: false,
+		},
+		"one child group and its clause": {
+			a:   ce("&&", se("$.a", coEqual, "b")),
+			b:   se("$.a", coEqual, "b"),
+			out: true,
+		},
+		"one child group and its mirrored clause": {
+			a:   ce("||", se("$.a", coLessThan, "10")),
+			b:   se("10", coGreaterThan, "$.a"),
+			out: true,
+		},
+		"one child group and another clause": {
+			a:   ce("&&", se("$.a", coEqual, "b")),
+			b:   se("$.a", coNotEqual, "b"),
+			out: false,
+		},
+		"one child groups with different operators": {
+			a:   ce("&&", se("$.a", coEqual, "b")),
+			b:   ce("||", se("$.a", coEqual, "b")),
+			out: true,
+		},
+		"nested one child groups": {
+			a:   ce("&&", ce("||", ce("&&", se("$.a", coEqual, "b")))),
+			b:   se("$.a", coEqual, "b"),
+			out: true,
+		},
+		"one child group and an IN clause": {
+			a:   ce("||", se("$.a", coEqual, "b")),
+			b:   sin("$.a", "b"),
+			out: true,
+		},
+		"one child group wrapping a group": {
+			a:   ce("&&", ce("||", se("$.a", coEqual, "b"), se("$.c", coEqual, "d"))),
+			b:   ce("||", se("$.c", coEqual, "d"), se("$.a", coEqual, "b")),
+			out: true,
+		},
+		"one child group inside a group": {
+			a:   ce("&&", ce("||", se("$.a", coEqual, "b")), se("$.c", coEqual, "d")),
+			b:   ce("&&", se("$.c", coEqual, "d"), se("$.a", coEqual, "b")),
+			out: true,
+		},
+		"one child group inside an OR set": {
+			a:   ce("||", ce("&&", se("$.a", coEqual, "b")), se("$.a", coEqual, "c")),
+			b:   ce("||", se("$.a", coEqual, "c"), se("$.a", coEqual, "b")),
+			out: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.out, tc.a.isEquivalent(tc.b))
+			require.Equal(t, tc.out, tc.b.isEquivalent(tc.a))
+		})
+	}
+}
+
+func TestComplexExpression_isEquivalent(t *testing.T) {
+	cases := map[string]struct {
+		a   Expression
+		b   Expression
+		out bool
+	}{
+		"same expression": {
+			a: ce("&&",
+				se("$.userIdentity.type", coEqual, "\"Root\""),
+				se("$.userIdentity.invokedBy", coNotExists, ""),
+				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
+			),
+			b: ce("&&",
+				se("$.userIdentity.type", coEqual, "\"Root\""),
+				se("$.userIdentity.invokedBy", coNotExists, ""),
+				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
+			),
+			out: true,
+		},
+		"different order": {
+			a: ce("&&",
+				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
+				se("\"Root\"", coEqual, "$.userIdentity.type"),
+				se("$.userIdentity.invokedBy", coNotExists, ""),
+			),
+			b: ce("&&",
+				se("$.userIdentity.invokedBy", coNotExists, ""),
+				se("$.userIdentity.type", coEqual, "\"Root\""),
+				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
+			),
+			out: true,
+		},
+		"same sub expressions": {
+			a: ce("&&",
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+				ce("||",
+					se("$.eventName", coEqual, "DisableKey"),
+					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+				),
+			),
+			b: ce("&&",
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+				ce("||",
+					se("$.eventName", coEqual, "DisableKey"),
+					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+				),
+			),
+			out: true,
+		},
+		"sub expressions different order": {
+			a: ce("&&",
+				ce("||",
+					se("$.eventName", coEqual, "DisableKey"),
+					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+				),
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+			),
+			b: ce("&&",
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+				ce("||",
+					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+					se("$.eventName", coEqual, "DisableKey"),
+				),
+			),
+			out: true,
+		},
+		"or of equals with swapped operands": {
+			a: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+			b: ce("||",
+				se("B", coEqual, "$.eventName"),
+				se("A", coEqual, "$.eventName"),
+			),
+			out: true,
+		},
+		"or of equals with bare selectors swapped": {
+			a: ce("||",
+				se("x", coEqual, "A"),
+				se("x", coEqual, "B"),
+			),
+			b: ce("||",
+				se("B", coEqual, "x"),
+				se("A", coEqual, "x"),
+			),
+			out: true,
+		},
+		"or of equals with different selectors": {
+			a: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+			b: ce("||",
+				se("$.eventSource", coEqual, "A"),
+				se("$.eventSource", coEqual, "B"),
+			),
+			out: false,
+		},
+		"or of equals with duplicates": {
+			a: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "A"),
+			),
+			b: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+			out: false,
+		},
+		"or of equals against mixed group": {
+			a: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+			b: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coNotEqual, "B"),
+			),
+			out: false,
+		},
+		"in operator and or of equals": {
+			a: sin("$.x", "a", "b"),
+			b: ce("||",
+				se("$.x", coEqual, "b"),
+				se("$.x", coEqual, "a"),
+			),
+			out: true,
+		},
+		"in operator and and of equals": {
+			a: sin("$.x", "a", "b"),
+			b: ce("&&",
+				se("$.x", coEqual, "b"),
+				se("$.x", coEqual, "a"),
+			),
+			out: false,
+		},
+		"in operator inside or group": {
+			a: ce("||",
+				sin("$.x", "a", "b"),
+				se("$.y", coEqual, "c"),
+			),
+			b: ce("||",
+				se("$.x", coEqual, "a"),
+				se("$.y", coEqual, "c"),
+				se("$.x", coEqual, "b"),
+			),
+			out: true,
+		},
+		"in operator inside and group": {
+			a: ce("&&",
+				sin("$.x", "a", "b"),
+				se("$.y", coEqual, "c"),
+			),
+			b: ce("&&",
+				se("$.y", coEqual, "c"),
+				ce("||",
+					se("$.x", coEqual, "a"),
+					se("$.x", coEqual, "b"),
+				),
+			),
+			out: true,
+		},
+		"not in operator and and of not equals": {
+			a: snotin("$.x", "a", "b"),
+			b: ce("&&",
+				se("$.x", coNotEqual, "b"),
+				se("$.x", coNotEqual, "a"),
+			),
+			out: true,
+		},
+		"not in operator and or of not equals": {
+			a: snotin("$.x", "a", "b"),
+			b: ce("||",
+				se("$.x", coNotEqual, "a"),
+				se("$.x", coNotEqual, "b"),
+			),
+			out: false,
+		},
+		"not in operator and and of equals": {
+			a: snotin("$.x", "a", "b"),
 			b: ce("&&",
-				se("$.userIdentity.invokedBy", coNotExists, ""),
-				se("$.userIdentity.type", coEqual, "\"Root\""),
-				se("$.eventType", coNotEqual, "\"AwsServiceEvent\""),
+				se("$.x", coEqual, "a"),
+				se("$.x", coEqual, "b"),
 			),
-			out: true,
+			out: false,
 		},
-		"same sub expressions": {
+		"not in operator and in operator": {
+			a:   snotin("$.x", "a", "b"),
+			b:   sin("$.x", "a", "b"),
+			out: false,
+		},
+		"not in operator inside and group": {
 			a: ce("&&",
-				se("$.eventSource", coEqual, "kms.amazonaws.com"),
-				ce("||",
-					se("$.eventName", coEqual, "DisableKey"),
-					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
-				),
+				snotin("$.x", "a", "b"),
+				se("$.y", coEqual, "c"),
 			),
 			b: ce("&&",
-				se("$.eventSource", coEqual, "kms.amazonaws.com"),
-				ce("||",
-					se("$.eventName", coEqual, "DisableKey"),
-					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
-				),
+				se("$.x", coNotEqual, "a"),
+				se("$.y", coEqual, "c"),
+				se("$.x", coNotEqual, "b"),
 			),
 			out: true,
 		},
-		"sub expressions different order": {
-			a: ce("&&",
-				ce("||",
-					se("$.eventName", coEqual, "DisableKey"),
-					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
-				),
-				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+		"not in operator inside or group": {
+			a: ce("||",
+				snotin("$.x", "a", "b"),
+				se("$.y", coEqual, "c"),
 			),
-			b: ce("&&",
-				se("$.eventSource", coEqual, "kms.amazonaws.com"),
-				ce("||",
-					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
-					se("$.eventName", coEqual, "DisableKey"),
+			b: ce("||",
+				se("$.y", coEqual, "c"),
+				ce("&&",
+					se("$.x", coNotEqual, "a"),
+					se("$.x", coNotEqual, "b"),
 				),
 			),
 			out: true,
@@ -333,6 +1786,214 @@ func TestComplexExpression_isEquivalent(t *testing.T) {
 	}
 }
 
+func TestComplexExpression_equalsSet(t *testing.T) {
+	cases := map[string]struct {
+		in       complexExpression
+		selector string
+		values   []string
+		ok       bool
+	}{
+		"or of equals": {
+			in: ce("||",
+				se("$.eventName", coEqual, "DeleteTrail"),
+				se("$.eventName", coEqual, "CreateTrail"),
+				se("UpdateTrail", coEqual, "$.eventName"),
+			),
+			selector: "$.eventName",
+			values:   []string{"CreateTrail", "DeleteTrail", "UpdateTrail"},
+			ok:       true,
+		},
+		"or of equals keeps duplicates": {
+			in: ce("||",
+				se("$.eventName", coEqual, "B"),
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+			selector: "$.eventName",
+			values:   []string{"A", "B", "B"},
+			ok:       true,
+		},
+		"and of equals": {
+			in: ce("&&",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coEqual, "B"),
+			),
+		},
+		"or of different selectors": {
+			in: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventSource", coEqual, "B"),
+			),
+		},
+		"or with different operator": {
+			in: ce("||",
+				se("$.eventName", coEqual, "A"),
+				se("$.eventName", coNotEqual, "B"),
+			),
+		},
+		"or with sub expression": {
+			in: ce("||",
+				se("$.eventName", coEqual, "A"),
+				ce("&&", se("$.eventName", coEqual, "B"), se("$.eventSource", coEqual, "C")),
+			),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			selector, values, ok := tc.in.equalsSet(CompareOptions{})
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.selector, selector)
+			require.Equal(t, tc.values, values)
+		})
+	}
+}
+
+func TestExpression_Equals(t *testing.T) {
+	cases := map[string]struct {
+		a   Expression
+		b   Expression
+		out bool
+	}{
+		"same simple expression": {
+			a:   se("a", coEqual, "b"),
+			b:   se("b", coEqual, "a"),
+			out: true,
+		},
+		"different simple expression": {
+			a:   se("a", coEqual, "b"),
+			b:   se("a", coNotEqual, "b"),
+			out: false,
+		},
+		"same complex expression": {
+			a:   ce("||", se("a", coEqual, "b"), se("c", coEqual, "d")),
+			b:   ce("||", se("c", coEqual, "d"), se("a", coEqual, "b")),
+			out: true,
+		},
+		"simple against complex": {
+			a:   se("a", coEqual, "b"),
+			b:   ce("||", se("a", coEqual, "b"), se("c", coEqual, "d")),
+			out: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.out, tc.a.Equals(tc.b))
+			require.Equal(t, tc.out, tc.b.Equals(tc.a))
+			require.Equal(t, tc.a.isEquivalent(tc.b), tc.a.Equals(tc.b))
+		})
+	}
+}
+
+func TestExpression_StructurallyEqual(t *testing.T) {
+	cases := map[string]struct {
+		a          string
+		b          string
+		structural bool
+		equivalent bool
+	}{
+		"same expression":            {a: "{ $.a = b && $.c != d }", b: "{ $.a = b && $.c != d }", structural: true, equivalent: true},
+		"spacing and parenthesis":    {a: "{ ($.a = b) && (($.c != d)) }", b: "{$.a=b&&$.c!=d}", structural: true, equivalent: true},
+		"reordered clauses":          {a: "{ $.a = b && $.c != d }", b: "{ $.c != d && $.a = b }", structural: false, equivalent: true},
+		"reordered nested clauses":   {a: "{ $.a = b && ($.c = d || $.e = f) }", b: "{ $.a = b && ($.e = f || $.c = d) }", structural: false, equivalent: true},
+		"swapped operands":           {a: "{ $.a = b }", b: "{ b = $.a }", structural: false, equivalent: true},
+		"mirrored operator":          {a: "{ $.a < 1 }", b: "{ 1 > $.a }", structural: false, equivalent: true},
+		"reordered IN list":          {a: "{ $.a IN [b, c] }", b: "{ $.a IN [c, b] }", structural: false, equivalent: true},
+		"IN list and OR group":       {a: "{ $.a IN [b, c] }", b: "{ $.a = b || $.a = c }", structural: false, equivalent: true},
+		"different value":            {a: "{ $.a = b }", b: "{ $.a = c }", structural: false, equivalent: false},
+		"different logical operator": {a: "{ $.a = b && $.c = d }", b: "{ $.a = b || $.c = d }", structural: false, equivalent: false},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, err := parse(tc.a)
+			require.NoError(t, err)
+			b, err := parse(tc.b)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.structural, a.StructurallyEqual(b))
+			require.Equal(t, tc.structural, b.StructurallyEqual(a))
+			require.Equal(t, tc.equivalent, a.Equals(b))
+			require.Equal(t, tc.equivalent, b.Equals(a))
+		})
+	}
+}
+
+func TestExpression_String(t *testing.T) {
+	cases := map[string]struct {
+		in  Expression
+		out string
+	}{
+		"simple expression": {
+			in:  se("$.eventName", coEqual, "DeleteGroupPolicy"),
+			out: "$.eventName = DeleteGroupPolicy",
+		},
+		"simple expression 'different' comparator": {
+			in:  se("$.eventName", coNotEqual, "\"HeadBucket\""),
+			out: "$.eventName != \"HeadBucket\"",
+		},
+		"simple expression 'notExists' comparator": {
+			in:  se("$.userIdentity.invokedBy", coNotExists, ""),
+			out: "$.userIdentity.invokedBy NOT EXISTS",
+		},
+		"simple expression 'in' comparator": {
+			in:  sin("$.eventName", "\"CreateTrail\"", "UpdateTrail"),
+			out: "$.eventName IN [\"CreateTrail\", UpdateTrail]",
+		},
+		"complex expression": {
+			in: ce("&&",
+				se("$.userIdentity.type", coEqual, "\"Root\""),
+				se("$.userIdentity.invokedBy", coNotExists, "")),
+			out: "$.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS",
+		},
+		"complex expression with sub expressions": {
+			in: ce("&&",
+				se("$.eventSource", coEqual, "kms.amazonaws.com"),
+				ce("||",
+					se("$.eventName", coEqual, "DisableKey"),
+					se("$.eventName", coEqual, "ScheduleKeyDeletion"),
+				),
+			),
+			out: "$.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion)",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.out, tc.in.String())
+		})
+	}
+}
+
+func TestExpression_Operator(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out string
+	}{
+		"equal":                 {in: "{ $.a=b }", out: "="},
+		"not equal":             {in: "{ $.a != b }", out: "!="},
+		"less than":             {in: "{ $.a < 1 }", out: "<"},
+		"less than or equal":    {in: "{ $.a<=1 }", out: "<="},
+		"greater than":          {in: "{ $.a > 1 }", out: ">"},
+		"greater than or equal": {in: "{ $.a >= 1 }", out: ">="},
+		"not exists":            {in: "{ $.a NOT EXISTS }", out: "NOT EXISTS"},
+		"in":                    {in: "{ $.a IN [b, c] }", out: "IN"},
+		"mirrored operands":     {in: "{ 1 < $.a }", out: "<"},
+		"and group":             {in: "{ $.a = b && $.c = d }", out: "&&"},
+		"or group":              {in: "{ ($.a = b) || ($.c = d && $.e = f) }", out: "||"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			exp, err := parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, exp.Operator())
+			require.Contains(t, exp.String()+" ", " "+tc.out+" ")
+		})
+	}
+}
+
 func TestAreCloudWatchExpressionsEquivalent(t *testing.T) {
 	cases := map[string]struct {
 		expA               string
@@ -491,17 +2152,186 @@ func TestAreCloudWatchExpressionsEquivalent(t *testing.T) {
 			shouldBeEquivalent: false,
 			err:                errors.New("not supported comparison with alternating logical operators"),
 		},
+
+		"Must not match on leading spaces inside quotes": {
+			expA:               "{ $.eventName = \"  AcceptHandshake\" }",
+			expB:               "{ $.eventName = \"AcceptHandshake\" }",
+			shouldBeEquivalent: false,
+		},
+
+		"Must match on spaces outside quotes": {
+			expA:               "{ (  $.eventName   =   \"  AcceptHandshake\"  ) }",
+			expB:               "{$.eventName=\"  AcceptHandshake\"}",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match on query strings in quoted values": {
+			expA:               "{ ($.requestParameters.url = \"/a?b=c\") && ($.status != \"x!=y\") }",
+			expB:               "{ \"x!=y\" != $.status && \"/a?b=c\" = $.requestParameters.url }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must not match on different query strings in quoted values": {
+			expA:               "{ $.requestParameters.url = \"/a?b=c\" }",
+			expB:               "{ $.requestParameters.url = \"/a?b=d\" }",
+			shouldBeEquivalent: false,
+		},
+
+		"Must match with parenthesized value": {
+			expA:               "{ $.eventName = (ConsoleLogin) }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with parenthesized clause and value": {
+			expA:               "{ ($.eventName = ConsoleLogin) && ($.a = (b)) }",
+			expB:               "{ (b) = $.a && $.eventName = ConsoleLogin }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with redundant parenthesis around a sub expression": {
+			expA:               "{($.a=b) && (($.c=d))}",
+			expB:               "{($.a=b) && ($.c=d)}",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with redundant parenthesis around a complex sub expression": {
+			expA:               "{ $.a=b && ((($.c=d) || ($.e=f))) }",
+			expB:               "{ $.a=b && ($.e=f || $.c=d) }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must not match with redundant parenthesis and a different clause": {
+			expA:               "{($.a=b) && (($.c=d))}",
+			expB:               "{($.a=b) && ($.c=e)}",
+			shouldBeEquivalent: false,
+		},
+
+		"Must match with reordered bare selectors": {
+			expA:               "{ eventName = ConsoleLogin && errorMessage = \"Failed authentication\" }",
+			expB:               "{ (errorMessage = \"Failed authentication\") && (eventName=ConsoleLogin) }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with mirrored bare selectors": {
+			expA:               "{ bytes > 1024 }",
+			expB:               "{ 1024 < bytes }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must not match bare and dollar selectors": {
+			expA:               "{ eventName = ConsoleLogin }",
+			expB:               "{ $.eventName = ConsoleLogin }",
+			shouldBeEquivalent: false,
+		},
+
+		"Must match swapped bare operands": {
+			expA:               "{ eventName = ConsoleLogin }",
+			expB:               "{ ConsoleLogin = eventName }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with differently grouped OR chains": {
+			expA:               "{ ($.eventName = CreateTrail || $.eventName = UpdateTrail) || $.eventName = DeleteTrail }",
+			expB:               "{ $.eventName = CreateTrail || ($.eventName = UpdateTrail || $.eventName = DeleteTrail) }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with templated OR chains grouped in pairs": {
+			expA:               "{ (($.errorCode = \"*UnauthorizedOperation\") || ($.errorCode = \"AccessDenied*\")) || (($.sourceIPAddress != \"delivery.logs.amazonaws.com\") || ($.eventName != \"HeadBucket\")) }",
+			expB:               "{ ($.errorCode = \"*UnauthorizedOperation\") || (($.errorCode = \"AccessDenied*\") || ($.sourceIPAddress != \"delivery.logs.amazonaws.com\")) || ($.eventName != \"HeadBucket\") }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match with a regrouped OR chain nested in an AND group": {
+			expA:               "{ $.eventSource = kms.amazonaws.com && (($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion) || $.eventName = DeleteAlias) }",
+			expB:               "{ ($.eventName = DeleteAlias || ($.eventName = ScheduleKeyDeletion || $.eventName = DisableKey)) && $.eventSource = kms.amazonaws.com }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must not match with a regrouped chain mixing operators": {
+			expA:               "{ ($.a = b || $.c = d) && $.e = f }",
+			expB:               "{ $.a = b || ($.c = d && $.e = f) }",
+			shouldBeEquivalent: false,
+		},
+
+		"Must not match with a regrouped OR chain missing a clause": {
+			expA:               "{ ($.a = b || $.c = d) || $.e = f }",
+			expB:               "{ $.a = b || ($.c = d || $.c = d) }",
+			shouldBeEquivalent: false,
+		},
+
+		"Must match an IN list of one value against an equal clause in an AND group": {
+			expA:               "{ $.a = x && $.b IN [y] }",
+			expB:               "{ $.a = x && $.b = y }",
+			shouldBeEquivalent: true,
+		},
+
+		"Must match an equal clause against an IN list of one value in an AND group": {
+			expA:               "{ $.b = y && $.a = x }",
+			expB:               "{ $.a = x && $.b IN [y] }",
+			shouldBeEquivalent: true,
+		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
 			areEquivalent, err := areCloudWatchExpressionsEquivalent(tc.expA, tc.expB)
-			require.Equal(t, tc.err, err)
+			requireCause(t, tc.err, err)
 			require.Equal(t, areEquivalent, tc.shouldBeEquivalent)
+
+			fast, err := EquivalentFast(tc.expA, tc.expB)
+			requireCause(t, tc.err, err)
+			require.Equal(t, tc.shouldBeEquivalent, fast)
+
+			if err == nil { // Equals on the parsed expressions must agree with the string based comparison
+				expA, errA := parse(tc.expA)
+				require.NoError(t, errA)
+				expB, errB := parse(tc.expB)
+				require.NoError(t, errB)
+				require.Equal(t, tc.shouldBeEquivalent, expA.Equals(expB))
+				if tc.shouldBeEquivalent {
+					require.Equal(t, Hash(expA), Hash(expB))
+				}
+			}
 		})
 	}
 }
 
+// requireCause checks that err is expected once unwrapped from the
+// SubExpressionErrors locating it
+func requireCause(t *testing.T, expected, err error) {
+	var subErr SubExpressionError
+	for errors.As(err, &subErr) {
+		err = subErr.Err
+	}
+
+	require.Equal(t, expected, err)
+}
+
+func TestParse_subExpressionErrors(t *testing.T) {
+	in := "{ ($.eventSource = kms.amazonaws.com) && (($.eventName = DisableKey) || ($.eventName ScheduleKeyDeletion)) }"
+	_, err := parse(in)
+	require.EqualError(t, err, "in \"($.eventName = DisableKey) || ($.eventName ScheduleKeyDeletion)\": "+
+		"in \"$.eventName ScheduleKeyDeletion\": could not find a operator for this expression")
+
+	var subErr SubExpressionError
+	require.ErrorAs(t, err, &subErr)
+	require.Equal(t, "($.eventName = DisableKey) || ($.eventName ScheduleKeyDeletion)", in[subErr.Span.StartByte:subErr.Span.EndByte])
+
+	inner := subErr.Err.(SubExpressionError)
+	require.Equal(t, "$.eventName ScheduleKeyDeletion", in[inner.Span.StartByte:inner.Span.EndByte])
+	require.Equal(t, errors.New("could not find a operator for this expression"), inner.Err)
+
+	// errors of the top level expression have nothing to locate
+	_, err = parse("{ $.eventSource = kms.amazonaws.com && $.eventName }")
+	require.Equal(t, errors.New("could not find a operator for this expression"), err)
+
+	// neither has the depth limit, which the whole nesting reaches
+	_, err = parse("{ a=b && (c=d || (e=f && (g=h || (i=j && (k=l || (m=n)))))) }")
+	require.Equal(t, ErrMaxDepthReached, err)
+}
+
 func BenchmarkAreCloudWatchExpressionsEquivalent(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		equivalent, err := areCloudWatchExpressionsEquivalent(
@@ -512,6 +2342,21 @@ func BenchmarkAreCloudWatchExpressionsEquivalent(b *testing.B) {
 	}
 }
 
+func BenchmarkComplexExpression_isEquivalent(b *testing.B) {
+	clausesA := make([]Expression, 0, 100)
+	clausesB := make([]Expression, 0, 100)
+	for i := 0; i < 100; i++ {
+		clausesA = append(clausesA, se("$.eventName", coNotEqual, "Event"+strconv.Itoa(i)))
+		clausesB = append(clausesB, se("Event"+strconv.Itoa(99-i), coNotEqual, "$.eventName"))
+	}
+	expA, expB := ce("&&", clausesA...), ce("&&", clausesB...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.True(b, expA.isEquivalent(expB))
+	}
+}
+
 func se(l string, c comparisonOperator, r string) simpleExpression {
 	return simpleExpression{
 		left:     l,
@@ -520,9 +2365,219 @@ func se(l string, c comparisonOperator, r string) simpleExpression {
 	}
 }
 
-func ce(c logicalOperator, expressions ...expression) complexExpression {
+func sin(l string, values ...string) simpleExpression {
+	return simpleExpression{
+		left:     l,
+		operator: coIn,
+		values:   values,
+	}
+}
+
+func snotin(l string, values ...string) simpleExpression {
+	return simpleExpression{
+		left:     l,
+		operator: coNotIn,
+		values:   values,
+	}
+}
+
+func ce(c logicalOperator, expressions ...Expression) complexExpression {
 	return complexExpression{
 		operator:    c,
 		expressions: expressions,
 	}
 }
+
+// withoutSpans clears the spans of a parsed expression so it can be compared
+// against the ones built by se, sin and ce
+func withoutSpans(e Expression) Expression {
+	switch exp := e.(type) {
+	case simpleExpression:
+		exp.span = Span{}
+		return exp
+	case complexExpression:
+		expressions := make([]Expression, 0, len(exp.expressions))
+		for _, sub := range exp.expressions {
+			expressions = append(expressions, withoutSpans(sub))
+		}
+		exp.expressions = expressions
+		exp.span = Span{}
+		return exp
+	}
+
+	return e
+}
+
+func TestParse_roundTrip(t *testing.T) {
+	inputs := []string{
+		"{$.eventName=DeleteGroupPolicy}",
+		"{   $. eventName = \" String string string  \" }",
+		"{   $.eventName NOT EXISTS }",
+		"{(((($.eventName=DeleteGroupPolicy))))}",
+		"{ $.a = }",
+		"{$.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }",
+		"{ ($.errorCode = \"*UnauthorizedOperation\") || ($.errorCode = \"AccessDenied*\") || ($.sourceIPAddress!=\"delivery.logs.amazonaws.com\") || ($.eventName!=\"HeadBucket\") }",
+		"{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+		"{ (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) && ($.eventSource = kms.amazonaws.com) }",
+		"{ ($.eventSource = s3.amazonaws.com) && (($.eventName = PutBucketAcl) || ($.eventName = PutBucketPolicy) || ($.eventName = PutBucketCors)) }",
+		"{((a=b) && ((c=d) || ((e=f) && (g!=h || (i=j)))))}",
+		"{ a=b && (c=d && (e=f)) }",
+		"{ (a=b || c=d) || (e=f || g=h) }",
+		"{ ($.msg != \"(a) && (b || c))\") || ($.level != \"||\") }",
+	}
+
+	for _, in := range inputs {
+		t.Run(in, func(t *testing.T) {
+			exp, err := parse(in)
+			require.NoError(t, err)
+
+			again, err := parse(in)
+			require.NoError(t, err)
+			require.Equal(t, exp, again, "parse must be deterministic")
+
+			reparsed, err := parse(exp.String())
+			require.NoError(t, err)
+			require.True(t, exp.isEquivalent(reparsed))
+			require.Equal(t, exp.String(), reparsed.String(), "String must be idempotent under re-parse")
+		})
+	}
+}
+
+func TestParse_spans(t *testing.T) {
+	in := "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||( $.eventName = \"Schedule)\" )) }"
+	exp, err := parse(in)
+	require.NoError(t, err)
+
+	spanOf := func(e Expression) string {
+		return in[e.Span().StartByte:e.Span().EndByte]
+	}
+
+	root := exp.(complexExpression)
+	require.Equal(t, "($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||( $.eventName = \"Schedule)\" ))", spanOf(root))
+	require.Equal(t, "$.eventSource = kms.amazonaws.com", spanOf(root.expressions[0]))
+
+	nested := root.expressions[1].(complexExpression)
+	require.Equal(t, "($.eventName=DisableKey)||( $.eventName = \"Schedule)\" )", spanOf(nested))
+	require.Equal(t, "$.eventName=DisableKey", spanOf(nested.expressions[0]))
+	require.Equal(t, "$.eventName = \"Schedule)\"", spanOf(nested.expressions[1]))
+}
+
+func TestParseWithOptions_spansWithComment(t *testing.T) {
+	in := "  { $.a = b || $.c != d }  # comment"
+	exp, err := ParseWithOptions(in, ParseOptions{StripTrailingComment: true})
+	require.NoError(t, err)
+
+	second := exp.(complexExpression).expressions[1]
+	require.Equal(t, "$.c != d", in[second.Span().StartByte:second.Span().EndByte])
+}
+
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		"{$.eventName=DeleteGroupPolicy}",
+		"{   $. eventName = \" String string string  \" }",
+		"{   $.eventName NOT EXISTS }",
+		"{(((($.eventName=DeleteGroupPolicy))))}",
+		"{   (   $.eventName  =   DeleteGroupPolicy ))   }",
+		"{   $.eventName !== a }",
+		"{$.userIdentity.type = \"Root\" && $.userIdentity.invokedBy NOT EXISTS && $.eventType != \"AwsServiceEvent\" }",
+		"{($.errorCode = \"*UnauthorizedOperation\") || ($.errorCode = \"AccessDenied*\") || ($.sourceIPAddress!=\"delivery.logs.amazonaws.com\") || ($.eventName!=\"HeadBucket\") }",
+		"{ (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) && ($.eventSource = kms.amazonaws.com) }",
+		"{($.eventSource = kms.amazonaws.com) && ($.eventName=DisableKey) || ($.eventName=ScheduleKeyDeletion)}",
+		"{((a=b) && ((c=d) || ((e=f) && (g!=h || (i=j)))))}",
+		"{((((((((((((a=b)&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))&&(a=b))}",
+		"{ ($.msg != \"(a) && (b || c))\") || ($.level != \"||\") }",
+		"{($.a=b) $.c=d}",
+		"{ $.eventName IN [\"a, b\", c] || $.INdex = LINK }",
+		"{ (x != $.a) || (($.b > 5) && ($.b > 5)) }",
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		exp, err := parse(in)
+		if err != nil {
+			return
+		}
+
+		reparsed, err := parse(exp.String())
+		require.NoError(t, err, "rendered %q from %q", exp.String(), in)
+		require.True(t, exp.isEquivalent(reparsed), "rendered %q from %q", exp.String(), in)
+		require.Equal(t, exp.String(), reparsed.String(), "rendered %q from %q", exp.String(), in)
+
+		simplified, err := Simplify(in)
+		require.NoError(t, err)
+		simplifiedExp, err := parse(simplified)
+		require.NoError(t, err, "simplified %q from %q", simplified, in)
+		require.True(t, simplifiedExp.Equals(exp), "simplified %q from %q", simplified, in)
+
+		canonical, err := Canonicalize(in)
+		require.NoError(t, err)
+		equivalent, err := EquivalentWithOptions(canonical, in, CompareOptions{QuoteInsensitiveValues: true})
+		require.NoError(t, err)
+		require.True(t, equivalent, "canonicalized %q from %q", canonical, in)
+	})
+}
+
+// TestConcurrentUse parses and compares the CIS filters from many goroutines
+// while registering operators, run it with -race to catch shared state
+func TestConcurrentUse(t *testing.T) {
+	data, err := os.ReadFile("testdata/describe-metric-filters.json")
+	require.NoError(t, err)
+
+	var out describeMetricFiltersOutput
+	require.NoError(t, json.Unmarshal(data, &out))
+
+	patterns := make([]string, 0, len(out.MetricFilters))
+	for _, filter := range out.MetricFilters {
+		patterns = append(patterns, filter.FilterPattern)
+	}
+
+	type result struct {
+		equivalent bool
+		err        error
+	}
+	expected := make([]result, len(patterns))
+	for i := range patterns {
+		expected[i].equivalent, expected[i].err = areCloudWatchExpressionsEquivalent(patterns[i], patterns[(i+1)%len(patterns)])
+	}
+
+	const goroutines, rounds, registered = 16, 50, 10
+	restoreOperatorsAfter(t)
+	for i := 0; i < registered; i++ {
+		registerForTest(t, "~"+strconv.Itoa(i))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // keeps registering while the others parse
+		defer wg.Done()
+		for i := registered; i < 2*registered; i++ {
+			RegisterComparisonOperator("~" + strconv.Itoa(i))
+		}
+	}()
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for i := range patterns {
+					equivalent, err := areCloudWatchExpressionsEquivalent(patterns[i], patterns[(i+1)%len(patterns)])
+					if equivalent != expected[i].equivalent || !errors.Is(err, expected[i].err) {
+						t.Errorf("comparing %q: got %v, %v", patterns[i], equivalent, err)
+						return
+					}
+
+					if exp, err := parse(patterns[i]); err == nil && !exp.isEquivalent(exp) {
+						t.Errorf("%q isn't equivalent to itself", patterns[i])
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
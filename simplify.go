@@ -0,0 +1,136 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Simplify parses s and renders it back without redundant parenthesis, nested
+// groups of the same logical operator and duplicated clauses. The result is
+// always equivalent to s.
+func Simplify(s string) (string, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	return simplify(exp).String(), nil
+}
+
+// MergeOr combines the OR filters a and b into a single OR filter, rendered in
+// canonical form but with the quotes kept, so overlapping metric filters can be
+// consolidated. Clauses equivalent across both filters are kept once, and IN
+// lists are merged as their values. Clauses only differing by their quotes,
+// like `$.a = "x"` and `$.a = x`, aren't equivalent and are both kept. Each
+// filter must be a single clause or an OR group of clauses.
+func MergeOr(a, b string) (string, error) {
+	clausesA, err := parseOrClauses(a)
+	if err != nil {
+		return "", err
+	}
+
+	clausesB, err := parseOrClauses(b)
+	if err != nil {
+		return "", err
+	}
+
+	clauses := make([]Expression, 0, len(clausesA)+len(clausesB))
+	for _, clause := range append(clausesA, clausesB...) {
+		clauses = appendUnique(clauses, clause)
+	}
+
+	slices.SortStableFunc(clauses, func(a, b Expression) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	merged := Expression(complexExpression{operator: loOr, expressions: clauses})
+	if len(clauses) == 1 {
+		merged = clauses[0]
+	}
+
+	return "{ " + merged.String() + " }", nil
+}
+
+// parseOrClauses parses s as a single clause or an OR group of clauses,
+// returning its clauses with the IN lists expanded
+func parseOrClauses(s string) ([]Expression, error) {
+	exp, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	group, ok := exp.(complexExpression)
+	if !ok {
+		group = complexExpression{operator: loOr, expressions: []Expression{exp}}
+	}
+
+	if group.operator != loOr {
+		return nil, errors.New("expected an OR group of simple clauses")
+	}
+
+	for _, clause := range group.expressions {
+		if _, ok := clause.(simpleExpression); !ok {
+			return nil, errors.New("expected an OR group of simple clauses")
+		}
+	}
+
+	clauses := group.withExpandedIn().expressions
+	for i, clause := range clauses {
+		clauses[i] = canonicalOperands(clause.(simpleExpression))
+	}
+
+	return clauses, nil
+}
+
+// canonicalOperands rewrites s selector first, with the selector in dot
+// notation and the values of its list sorted, as Canonicalize renders it.
+// Quotes are kept, as they make values different.
+func canonicalOperands(s simpleExpression) simpleExpression {
+	s = s.selectorFirst()
+	s.left = canonicalizeSelector(s.left)
+	if s.values != nil {
+		s.values = slices.Clone(s.values)
+		slices.Sort(s.values)
+	}
+
+	return s
+}
+
+func simplify(e Expression) Expression {
+	c, ok := e.(complexExpression)
+	if !ok {
+		return e
+	}
+
+	expressions := make([]Expression, 0, len(c.expressions))
+	for _, exp := range c.expressions {
+		exp = simplify(exp)
+
+		// (a && b) && c is the same as a && b && c
+		if sub, ok := exp.(complexExpression); ok && sub.operator == c.operator {
+			for _, subExp := range sub.expressions {
+				expressions = appendUnique(expressions, subExp)
+			}
+			continue
+		}
+
+		expressions = appendUnique(expressions, exp)
+	}
+
+	if len(expressions) == 1 {
+		return expressions[0]
+	}
+
+	return complexExpression{operator: c.operator, expressions: expressions}
+}
+
+func appendUnique(expressions []Expression, exp Expression) []Expression {
+	for _, other := range expressions {
+		if other.isEquivalent(exp) {
+			return expressions
+		}
+	}
+
+	return append(expressions, exp)
+}
@@ -0,0 +1,131 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out string
+		err error
+	}{
+		"simple expression": {
+			in:  "{ $.a = b }",
+			out: "$.a = b",
+		},
+		"redundant parenthesis": {
+			in:  "{((a=b))}",
+			out: "a = b",
+		},
+		"not exists": {
+			in:  "{ ($.a NOT EXISTS) }",
+			out: "$.a NOT EXISTS",
+		},
+		"duplicated or clauses": {
+			in:  "{ ($.eventName = A) || ($.eventName = B) || ($.eventName = A) }",
+			out: "$.eventName = A || $.eventName = B",
+		},
+		"duplicated clauses with swapped operands": {
+			in:  "{ ($.eventName = A) || (A = $.eventName) }",
+			out: "$.eventName = A",
+		},
+		"nested groups of the same operator": {
+			in:  "{ $.a = b || ($.c = d || ($.e = f)) }",
+			out: "$.a = b || $.c = d || $.e = f",
+		},
+		"nested groups of different operators": {
+			in:  "{ ($.eventSource = kms.amazonaws.com) && (($.eventName=DisableKey)||($.eventName=ScheduleKeyDeletion)) }",
+			out: "$.eventSource = kms.amazonaws.com && ($.eventName = DisableKey || $.eventName = ScheduleKeyDeletion)",
+		},
+		"duplicated groups": {
+			in:  "{ ($.a = b) && ($.c = d || $.e = f) && ($.e = f || $.c = d) }",
+			out: "$.a = b && ($.c = d || $.e = f)",
+		},
+		"duplicated clauses in a nested group": {
+			in:  "{ (x != $.a) || (($.b > 5) && ($.b > 5)) }",
+			out: "x != $.a || $.b > 5",
+		},
+		"error on broken expression": {
+			in:  "{ ($.a = b }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := Simplify(tc.in)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+
+			if err == nil { // the rendered output must parse back to the simplified expression, equivalent to the input
+				exp, err := parse(tc.in)
+				require.NoError(t, err)
+				simplified, err := parse(out)
+				require.NoError(t, err)
+				require.True(t, simplify(exp).isEquivalent(simplified))
+				require.True(t, simplified.Equals(exp))
+			}
+		})
+	}
+}
+
+func TestMergeOr(t *testing.T) {
+	cases := map[string]struct {
+		a   string
+		b   string
+		out string
+		err error
+	}{
+		"IAM policy filters with overlap": {
+			a:   "{($.eventName=DeleteGroupPolicy)||($.eventName=DeleteRolePolicy)||($.eventName=DeleteUserPolicy)||($.eventName=PutGroupPolicy)}",
+			b:   "{ ($.eventName = PutGroupPolicy) || (DeleteRolePolicy = $.eventName) || ($.eventName = CreatePolicy) }",
+			out: "{ $.eventName = CreatePolicy || $.eventName = DeleteGroupPolicy || $.eventName = DeleteRolePolicy || $.eventName = DeleteUserPolicy || $.eventName = PutGroupPolicy }",
+		},
+		"IN lists": {
+			a:   "{ $.eventName IN [CreatePolicy, DeletePolicy] }",
+			b:   "{ $.eventName = DeletePolicy || $.eventSource = iam.amazonaws.com }",
+			out: "{ $.eventName = CreatePolicy || $.eventName = DeletePolicy || $.eventSource = iam.amazonaws.com }",
+		},
+		"same clause": {
+			a:   "{ $.eventName = ConsoleLogin }",
+			b:   "{ (ConsoleLogin = $.eventName) }",
+			out: "{ $.eventName = ConsoleLogin }",
+		},
+		"same clause with quotes": {
+			a:   "{ $.a = x || $.a = y }",
+			b:   `{ $.a = "x" || $.a = "y" }`,
+			out: `{ $.a = "x" || $.a = "y" || $.a = x || $.a = y }`,
+		},
+		"same clause with bracket notation": {
+			a:   `{ $["eventName"] = ConsoleLogin || $.eventName NOT IN [b, "a"] }`,
+			b:   `{ $.eventName = ConsoleLogin || $.eventName NOT IN ["a", b] }`,
+			out: `{ $.eventName = ConsoleLogin || $.eventName NOT IN ["a", b] }`,
+		},
+		"error on AND group": {
+			a:   "{ $.eventName = ConsoleLogin }",
+			b:   "{ $.eventName = ConsoleLogin && $.errorMessage = \"Failed authentication\" }",
+			err: errors.New("expected an OR group of simple clauses"),
+		},
+		"error on nested group": {
+			a:   "{ $.eventName = ConsoleLogin || ($.a = b && $.c = d) }",
+			b:   "{ $.eventName = ConsoleLogin }",
+			err: errors.New("expected an OR group of simple clauses"),
+		},
+		"error on unparseable filter": {
+			a:   "{ $.eventName = ConsoleLogin }",
+			b:   "{ ($.eventName = ConsoleLogin }",
+			err: errors.New("broken parenthesis"),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := MergeOr(tc.a, tc.b)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
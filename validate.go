@@ -0,0 +1,143 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"strings"
+)
+
+// ValidateOptions tweaks ValidateCloudWatchFilterWithOptions. The zero value
+// only checks what PutMetricFilter requires.
+type ValidateOptions struct {
+	// RequireQuotedStrings reports string values written without quotes,
+	// like `$.eventName = CreatePolicy`, to enforce a style guide. Numbers and
+	// the booleans true and false may stay unquoted.
+	RequireQuotedStrings bool
+}
+
+// Violation is a rule broken by a filter, Span locating the offending part of
+// the validated filter
+type Violation struct {
+	Message string
+	Span    Span
+}
+
+func (v Violation) Error() string {
+	return v.Message
+}
+
+// ValidateCloudWatchFilter checks that s is accepted by PutMetricFilter, which
+// is stricter than Parse: the filter must be wrapped in braces, selectors must
+// start with `$.` and values with special characters must be quoted. Every
+// violation found is reported as a Violation, joined in a single error. Filters
+// that can't be parsed only return the parse error.
+func ValidateCloudWatchFilter(s string) error {
+	return ValidateCloudWatchFilterWithOptions(s, ValidateOptions{})
+}
+
+// ValidateCloudWatchFilterWithOptions is ValidateCloudWatchFilter with the
+// extra checks enabled in opts.
+func ValidateCloudWatchFilterWithOptions(s string, opts ValidateOptions) error {
+	exp, err := parse(s)
+	if err != nil {
+		return err
+	}
+
+	var violations []error
+	start, end := trimSpan(s, 0, len(s))
+	if !strings.HasPrefix(s[start:end], "{") || !strings.HasSuffix(s[start:end], "}") {
+		violations = append(violations, Violation{Message: "filter must be wrapped in braces", Span: Span{StartByte: start, EndByte: end}})
+	}
+
+	for _, clause := range simpleClauses(exp) {
+		if !strings.HasPrefix(clause.left, "$.") {
+			violations = append(violations, Violation{Message: "selector must start with $. in `" + clause.String() + "`", Span: clause.span})
+		}
+
+		if clause.operator == coRegexMatch {
+			violations = append(violations, Violation{Message: "operator =~ isn't supported by CloudWatch in `" + clause.String() + "`", Span: clause.span})
+		}
+
+		values := clause.values
+		if !clause.operator.isList() {
+			values = []string{clause.right}
+		}
+
+		for _, v := range values {
+			if needsQuotes(v) {
+				violations = append(violations, Violation{Message: "value " + v + " must be quoted in `" + clause.String() + "`", Span: operandSpan(s, clause.span, v)})
+			} else if opts.RequireQuotedStrings && isUnquotedString(v) {
+				violations = append(violations, Violation{Message: "string value " + v + " must be quoted in `" + clause.String() + "`", Span: operandSpan(s, clause.span, v)})
+			}
+		}
+	}
+
+	return errors.Join(violations...)
+}
+
+// maxFilterPatternBytes is the size limit of a filter pattern in PutMetricFilter
+const maxFilterPatternBytes = 1024
+
+// WithinSizeLimit reports whether the filter s fits the 1024 bytes CloudWatch
+// accepts for a filter pattern, along with its size. The size is the one of
+// the canonical form of s, see Canonicalize, so spacing and redundant
+// parenthesis don't count. Filters that can't be parsed are measured as they
+// are written.
+func WithinSizeLimit(s string) (bool, int) {
+	size := len(s)
+	if canonical, err := Canonicalize(s); err == nil {
+		size = len(canonical)
+	}
+
+	return size <= maxFilterPatternBytes, size
+}
+
+// simpleClauses lists the simple expressions of e, in the order they're written
+func simpleClauses(e Expression) []simpleExpression {
+	if s, ok := e.(simpleExpression); ok {
+		return []simpleExpression{s}
+	}
+
+	clauses := make([]simpleExpression, 0)
+	for _, exp := range e.(complexExpression).expressions {
+		clauses = append(clauses, simpleClauses(exp)...)
+	}
+
+	return clauses
+}
+
+// operandSpan locates the operand v of the clause found at span of src,
+// looking after the operator first
+func operandSpan(src string, span Span, v string) Span {
+	text := src[span.StartByte:span.EndByte]
+	pos, length, _ := findComparisonOp(text)
+	start := strings.Index(text[pos+length:], v)
+	if start >= 0 {
+		start += pos + length
+	} else {
+		start = strings.Index(text[:pos], v)
+	}
+
+	return Span{StartByte: span.StartByte + start, EndByte: span.StartByte + start + len(v)}
+}
+
+// needsQuotes tells if the unquoted value v has characters other than the
+// ones of a word or the `*` wildcard
+func needsQuotes(v string) bool {
+	if strings.HasPrefix(v, "\"") {
+		return false
+	}
+
+	for _, r := range v {
+		if !isWordChar(r) && r != '*' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isUnquotedString tells if v is an unquoted value other than a literal, see
+// isLiteral
+func isUnquotedString(v string) bool {
+	return v != "" && !strings.HasPrefix(v, "\"") && !isLiteral(v)
+}
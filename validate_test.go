@@ -0,0 +1,171 @@
+package cloudwatch_lep
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestValidateCloudWatchFilter(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		err string
+	}{
+		"valid filter": {
+			in: "{ ($.eventSource = kms.amazonaws.com) && (($.eventName = DisableKey) || ($.eventName = \"Schedule Key Deletion\")) }",
+		},
+		"valid filter with wildcard, numbers and lists": {
+			in: "{ $.errorCode = *UnauthorizedOperation && $.bytes > 10 && $.eventName IN [Create-Key, \"a b\"] && $.a NOT EXISTS }",
+		},
+		"missing braces": {
+			in:  "$.eventName = ConsoleLogin",
+			err: "filter must be wrapped in braces",
+		},
+		"selector without $.": {
+			in:  "{ eventName = ConsoleLogin }",
+			err: "selector must start with $. in `eventName = ConsoleLogin`",
+		},
+		"value with special characters": {
+			in:  "{ $.userIdentity.arn = arn:aws:iam::123:root }",
+			err: "value arn:aws:iam::123:root must be quoted in `$.userIdentity.arn = arn:aws:iam::123:root`",
+		},
+		"list value with special characters": {
+			in:  "{ $.eventName IN [a, b/c] }",
+			err: "value b/c must be quoted in `$.eventName IN [a, b/c]`",
+		},
+		"regex match": {
+			in:  "{ $.userAgent =~ \"^aws-cli/.*\" }",
+			err: "operator =~ isn't supported by CloudWatch in `$.userAgent =~ \"^aws-cli/.*\"`",
+		},
+		"every violation": {
+			in: "eventName = a/b && $.c = d && e = f",
+			err: "filter must be wrapped in braces\n" +
+				"selector must start with $. in `eventName = a/b`\n" +
+				"value a/b must be quoted in `eventName = a/b`\n" +
+				"selector must start with $. in `e = f`",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateCloudWatchFilter(tc.in)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestValidateCloudWatchFilter_parseError(t *testing.T) {
+	err := ValidateCloudWatchFilter("{ ($.a = b) && $.c = d || $.e = f }")
+	require.Equal(t, ErrAlternatingLogicalOperators, err)
+	require.Equal(t, errors.New("broken parenthesis"), ValidateCloudWatchFilter("{ ($.a = b }"))
+}
+
+func TestValidateCloudWatchFilterWithOptions_requireQuotedStrings(t *testing.T) {
+	opts := ValidateOptions{RequireQuotedStrings: true}
+
+	cases := map[string]struct {
+		in      string
+		err     string
+		written []string // the text of the filter at each violation span
+	}{
+		"quoted strings": {
+			in: "{ $.eventName = \"CreatePolicy\" && $.eventSource IN [\"iam.amazonaws.com\"] }",
+		},
+		"numbers and literals": {
+			in: "{ $.bytes > 10 && $.ratio <= 0.5 && $.readOnly = false && $.errorCode = null && $.a NOT EXISTS }",
+		},
+		"unquoted string": {
+			in:      "{ $.eventName = CreatePolicy }",
+			err:     "string value CreatePolicy must be quoted in `$.eventName = CreatePolicy`",
+			written: []string{"CreatePolicy"},
+		},
+		"unquoted list values": {
+			in: "{ $.eventName IN [\"a\", b, 1] && $.errorCode = *Unauthorized }",
+			err: "string value b must be quoted in `$.eventName IN [\"a\", b, 1]`\n" +
+				"string value *Unauthorized must be quoted in `$.errorCode = *Unauthorized`",
+			written: []string{"b", "*Unauthorized"},
+		},
+		"value equal to its selector's name": {
+			in:      "{ $.a=a }",
+			err:     "string value a must be quoted in `$.a = a`",
+			written: []string{"a"},
+		},
+		"with other violations": {
+			in: "{ eventName = a/b && $.c = d }",
+			err: "selector must start with $. in `eventName = a/b`\n" +
+				"value a/b must be quoted in `eventName = a/b`\n" +
+				"string value d must be quoted in `$.c = d`",
+			written: []string{"eventName = a/b", "a/b", "d"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateCloudWatchFilterWithOptions(tc.in, opts)
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.err)
+
+			var written []string
+			for _, violation := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var v Violation
+				require.True(t, errors.As(violation, &v))
+				written = append(written, tc.in[v.Span.StartByte:v.Span.EndByte])
+			}
+			require.Equal(t, tc.written, written)
+		})
+	}
+}
+
+func TestValidateCloudWatchFilter_violationSpans(t *testing.T) {
+	in := "  $.a = \"b c\" && e = f "
+	err := ValidateCloudWatchFilter(in)
+	require.Error(t, err)
+
+	var v Violation
+	require.True(t, errors.As(err, &v))
+	require.Equal(t, "filter must be wrapped in braces", v.Message)
+	require.Equal(t, Span{StartByte: 2, EndByte: 22}, v.Span)
+}
+
+func TestWithinSizeLimit(t *testing.T) {
+	// an OR chain of event names, padded so its canonical form is size bytes
+	orChain := func(size int, spacing string) string {
+		clauses := make([]string, 0)
+		for i := 0; len(clauses)*len(" || $.eventName = Event00") < size-100; i++ {
+			clauses = append(clauses, fmt.Sprintf("$.eventName = Event%02d", i))
+		}
+		canonical := "{ " + strings.Join(clauses, " || ") + " || $.eventName = Z }"
+		last := "Z" + strings.Repeat("z", size-len(canonical))
+
+		return "{" + spacing + strings.Join(clauses, spacing+"||"+spacing) + spacing + "||" + spacing + "($.eventName=" + last + ")" + spacing + "}"
+	}
+
+	within, size := WithinSizeLimit(orChain(1024, " "))
+	require.True(t, within)
+	require.Equal(t, 1024, size)
+
+	within, size = WithinSizeLimit(orChain(1025, " "))
+	require.False(t, within)
+	require.Equal(t, 1025, size)
+
+	// spacing and parenthesis don't count
+	spaced := orChain(1024, "   ")
+	require.Greater(t, len(spaced), 1024)
+	within, size = WithinSizeLimit(spaced)
+	require.True(t, within)
+	require.Equal(t, 1024, size)
+
+	// filters that can't be parsed are measured as written
+	within, size = WithinSizeLimit("{ ($.a = b }")
+	require.True(t, within)
+	require.Equal(t, 12, size)
+}